@@ -1,9 +1,9 @@
 package main
 
 import (
-	"io/ioutil"
-	"os"
-	"strconv"
+	"errors"
+	"fmt"
+	"net"
 	"strings"
 	"time"
 )
@@ -16,36 +16,151 @@ type Config struct {
 	EtcdCertFile  string
 	EtcdKeyFile   string
 	EtcdCAFile    string
+	EtcdUsername  string
+	EtcdPassword  string
+	
+	// Log planned etcd changes instead of writing them
+	DryRun bool
 	
 	// DNS configuration
 	DNSTarget     string
 	RecordTTL     int
 	Domain        string
+	PTRRecords    bool
+	ReverseZones  []string
+	
+	// How to find the DNS target when DNS_TARGET is not set
+	DNSTargetResolvers []string
+	
+	// Docker targets per domain and per Traefik entrypoint
+	DomainTargets      []string
+	EntrypointTargets  []string
+	
+	// TTL overrides
+	DockerRecordTTL  int
+	ProxmoxRecordTTL int
+	DomainTTLs       []string
+	WarmingTTL       int
+	WarmingPeriod    time.Duration
 	
 	// Agent mode
 	AgentMode     string
+	AgentID       string
+	
+	// What to do when a name already holds records of another owner
+	ConflictPolicy string
+	
+	// What to do when managed records are changed outside DNSherpa
+	WatchPolicy string
+	
+	// Leader election between Proxmox replicas
+	LeaderElection       bool
+	LeaderElectionPrefix string
+	LeaderElectionTTL    int
+	
+	// Address of the HTTP status API, "off" to disable it
+	HTTPListen string
+	
+	// Missed Proxmox poll intervals after which a cluster is unhealthy
+	HealthSyncIntervals int
 	
 	// Proxmox configuration
 	ProxmoxAPIURL        string
 	ProxmoxTokenID       string
 	ProxmoxTokenSecret   string
+	ProxmoxUsername      string
+	ProxmoxPassword      string
+	ProxmoxRealm         string
 	ProxmoxPollInterval  time.Duration
 	ProxmoxVerifySSL     bool
+	ProxmoxCAFile        string
+	ProxmoxFingerprints  []string
 	ProxmoxInterface     string
 	ProxmoxMultiIPv4     string
+	
+	// Proxmox guest selection
+	ProxmoxOptIn         bool
+	ProxmoxOptInTag      string
+	ProxmoxIncludePools  []string
+	ProxmoxExcludePools  []string
+	ProxmoxIncludeNodes  []string
+	ProxmoxExcludeNodes  []string
+	ProxmoxIncludeVMIDs  []string
+	ProxmoxExcludeVMIDs  []string
+	ProxmoxIncludeName   string
+	ProxmoxExcludeName   string
+	ProxmoxIncludeTags   []string
+	ProxmoxExcludeTags   []string
+	
+	// Proxmox clusters to monitor, built from the settings above
+	ProxmoxClusters      []ProxmoxClusterConfig
+	
+	// Split-horizon views published next to the default one
+	Views                []ViewConfig
+	
+	// Files secrets were read from, watched for rotation
+	SecretFiles          []string
+}
+
+// ProxmoxClusterConfig holds the connection settings and defaults of one Proxmox cluster
+type ProxmoxClusterConfig struct {
+	Name         string
+	APIURLs      []string
+	TokenID      string
+	TokenSecret  string
+	Username     string
+	Password     string
+	Realm        string
+	VerifySSL    bool
+	CAFile       string
+	Fingerprints []string
+	PollInterval time.Duration
+	Interface    string
+	MultiIPv4    string
+	Domain       string
+	RecordTTL    int
+}
+
+// ViewConfig describes a split-horizon view: a second copy of the records
+// under its own etcd prefix, usually served by a separate CoreDNS server block
+type ViewConfig struct {
+	Name   string
+	Prefix string
+	Target string
 }
 
-func LoadConfig() Config {
-	etcdEndpoints := strings.Split(getEnv("ETCD_ENDPOINTS", "172.16.0.221:2379,172.16.0.222:2379"), ",")
+// LoadConfig reads the configuration from the environment and the config file
+// loaded with loadConfigFile. Environment variables override the file. Every
+// malformed or inconsistent value is reported in the returned error.
+func LoadConfig() (Config, error) {
+	p := &configParser{}
+	
+	etcdEndpoints := p.list("ETCD_ENDPOINTS", []string{"172.16.0.221:2379", "172.16.0.222:2379"})
 	
 	// Parse TLS setting
-	etcdTLS, _ := strconv.ParseBool(getEnv("ETCD_TLS", "false"))
+	etcdTLS := p.bool("ETCD_TLS", false)
+	dryRun := p.bool("DRY_RUN", false)
 	
 	// Parse Proxmox settings
-	proxmoxVerifySSL, _ := strconv.ParseBool(getEnv("PROXMOX_VERIFY_SSL", "false"))
-	proxmoxPollInterval, _ := time.ParseDuration(getEnv("PROXMOX_POLL_INTERVAL", "30s"))
+	proxmoxVerifySSL := p.bool("PROXMOX_VERIFY_SSL", false)
+	proxmoxPollInterval := p.duration("PROXMOX_POLL_INTERVAL", 30*time.Second)
+	proxmoxOptIn := p.bool("PROXMOX_OPT_IN", false)
+	
+	// Parse reverse DNS settings
+	ptrRecords := p.bool("PTR_RECORDS", false)
+	
+	// Parse high availability settings
+	leaderElection := p.bool("LEADER_ELECTION", false)
+	leaderElectionTTL := p.int("LEADER_ELECTION_TTL", 10)
 	
-	return Config{
+	// Parse TTL settings
+	recordTTL := p.int("RECORD_TTL", 300)
+	dockerRecordTTL := p.int("DOCKER_RECORD_TTL", 0)
+	proxmoxRecordTTL := p.int("PROXMOX_RECORD_TTL", 0)
+	warmingTTL := p.int("WARMING_TTL", 0)
+	warmingPeriod := p.duration("WARMING_PERIOD", 10*time.Minute)
+	
+	config := Config{
 		// etcd configuration
 		EtcdEndpoints: etcdEndpoints,
 		EtcdPrefix:    getEnv("ETCD_PREFIX", "/skydns"),
@@ -53,86 +168,328 @@ func LoadConfig() Config {
 		EtcdCertFile:  getEnv("ETCD_CERT_FILE", ""),
 		EtcdKeyFile:   getEnv("ETCD_KEY_FILE", ""),
 		EtcdCAFile:    getEnv("ETCD_CA_FILE", ""),
+		EtcdUsername:  getEnv("ETCD_USERNAME", ""),
+		EtcdPassword:  p.secret("ETCD_PASSWORD", ""),
+		DryRun:        dryRun,
 		
 		// DNS configuration
-		DNSTarget:     detectDNSTarget(),
-		RecordTTL:     300,
+		DNSTarget:     getEnv("DNS_TARGET", ""),
+		DNSTargetResolvers: getEnvList("DNS_TARGET_RESOLVERS"),
+		DomainTargets:      getEnvList("DOMAIN_TARGETS"),
+		EntrypointTargets:  getEnvList("ENTRYPOINT_TARGETS"),
+		RecordTTL:     recordTTL,
 		Domain:        getEnv("DOMAIN", ""),
+		PTRRecords:    ptrRecords,
+		ReverseZones:  getEnvList("REVERSE_ZONES"),
+		
+		// TTL overrides
+		DockerRecordTTL:  dockerRecordTTL,
+		ProxmoxRecordTTL: proxmoxRecordTTL,
+		DomainTTLs:       getEnvList("DOMAIN_TTLS"),
+		WarmingTTL:       warmingTTL,
+		WarmingPeriod:    warmingPeriod,
 		
 		// Agent mode
 		AgentMode:     getEnv("AGENT_MODE", "docker"),
+		AgentID:       getEnv("AGENT_ID", ""),
+		
+		ConflictPolicy: getEnv("CONFLICT_POLICY", ConflictMerge),
+		WatchPolicy:    getEnv("WATCH_POLICY", WatchRepair),
+		
+		LeaderElection:       leaderElection,
+		LeaderElectionPrefix: getEnv("LEADER_ELECTION_PREFIX", "/dnsherpa/election/proxmox"),
+		LeaderElectionTTL:    leaderElectionTTL,
+		HTTPListen:           getEnv("HTTP_LISTEN", defaultHTTPListen),
+		HealthSyncIntervals:  p.int("HEALTH_SYNC_INTERVALS", 3),
 		
 		// Proxmox configuration
 		ProxmoxAPIURL:        getEnv("PROXMOX_API_URL", ""),
 		ProxmoxTokenID:       getEnv("PROXMOX_TOKEN_ID", ""),
-		ProxmoxTokenSecret:   getEnv("PROXMOX_TOKEN_SECRET", ""),
+		ProxmoxTokenSecret:   p.secret("PROXMOX_TOKEN_SECRET", ""),
+		ProxmoxUsername:      getEnv("PROXMOX_USERNAME", ""),
+		ProxmoxPassword:      p.secret("PROXMOX_PASSWORD", ""),
+		ProxmoxRealm:         getEnv("PROXMOX_REALM", "pam"),
 		ProxmoxPollInterval:  proxmoxPollInterval,
 		ProxmoxVerifySSL:     proxmoxVerifySSL,
+		ProxmoxCAFile:        getEnv("PROXMOX_CA_FILE", ""),
+		ProxmoxFingerprints:  getEnvList("PROXMOX_TLS_FINGERPRINT"),
 		ProxmoxInterface:     getEnv("PROXMOX_INTERFACE", "eth0"),
 		ProxmoxMultiIPv4:     getEnv("PROXMOX_MULTI_IPV4", "first"),
+		
+		// Proxmox guest selection
+		ProxmoxOptIn:         proxmoxOptIn,
+		ProxmoxOptInTag:      getEnv("PROXMOX_OPT_IN_TAG", "dnsherpa"),
+		ProxmoxIncludePools:  getEnvList("PROXMOX_INCLUDE_POOLS"),
+		ProxmoxExcludePools:  getEnvList("PROXMOX_EXCLUDE_POOLS"),
+		ProxmoxIncludeNodes:  getEnvList("PROXMOX_INCLUDE_NODES"),
+		ProxmoxExcludeNodes:  getEnvList("PROXMOX_EXCLUDE_NODES"),
+		ProxmoxIncludeVMIDs:  getEnvList("PROXMOX_INCLUDE_VMIDS"),
+		ProxmoxExcludeVMIDs:  getEnvList("PROXMOX_EXCLUDE_VMIDS"),
+		ProxmoxIncludeName:   getEnv("PROXMOX_INCLUDE_NAME", ""),
+		ProxmoxExcludeName:   getEnv("PROXMOX_EXCLUDE_NAME", ""),
+		ProxmoxIncludeTags:   getEnvList("PROXMOX_INCLUDE_TAGS"),
+		ProxmoxExcludeTags:   getEnvList("PROXMOX_EXCLUDE_TAGS"),
+	}
+	
+	config.ProxmoxClusters = loadProxmoxClusters(config, p)
+	config.Views = loadViews()
+	config.SecretFiles = p.files
+	
+	if err := errors.Join(p.errs...); err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+// loadProxmoxClusters builds the cluster list. Without PROXMOX_CLUSTERS the global
+// PROXMOX_* settings describe a single cluster named "default". With it, each named
+// cluster reads PROXMOX_<NAME>_* variables and falls back to the global settings,
+// except for the API URL which every cluster must set itself. API URLs may list
+// several nodes of the same cluster for failover.
+func loadProxmoxClusters(config Config, p *configParser) []ProxmoxClusterConfig {
+	defaults := ProxmoxClusterConfig{
+		Name:         "default",
+		APIURLs:      splitList(config.ProxmoxAPIURL),
+		TokenID:      config.ProxmoxTokenID,
+		TokenSecret:  config.ProxmoxTokenSecret,
+		Username:     config.ProxmoxUsername,
+		Password:     config.ProxmoxPassword,
+		Realm:        config.ProxmoxRealm,
+		VerifySSL:    config.ProxmoxVerifySSL,
+		CAFile:       config.ProxmoxCAFile,
+		Fingerprints: config.ProxmoxFingerprints,
+		PollInterval: config.ProxmoxPollInterval,
+		Interface:    config.ProxmoxInterface,
+		MultiIPv4:    config.ProxmoxMultiIPv4,
+		Domain:       config.Domain,
+		RecordTTL:    config.ProxmoxRecordTTL,
+	}
+	
+	names := getEnvList("PROXMOX_CLUSTERS")
+	if len(names) == 0 {
+		if len(defaults.APIURLs) == 0 {
+			return nil
+		}
+		return []ProxmoxClusterConfig{defaults}
+	}
+	
+	var clusters []ProxmoxClusterConfig
+	for _, name := range names {
+		prefix := "PROXMOX_" + envName(name) + "_"
+		
+		clusters = append(clusters, ProxmoxClusterConfig{
+			Name:         name,
+			APIURLs:      getEnvList(prefix + "API_URL"),
+			TokenID:      getEnv(prefix+"TOKEN_ID", defaults.TokenID),
+			TokenSecret:  p.secret(prefix+"TOKEN_SECRET", defaults.TokenSecret),
+			Username:     getEnv(prefix+"USERNAME", defaults.Username),
+			Password:     p.secret(prefix+"PASSWORD", defaults.Password),
+			Realm:        getEnv(prefix+"REALM", defaults.Realm),
+			VerifySSL:    p.bool(prefix+"VERIFY_SSL", defaults.VerifySSL),
+			CAFile:       getEnv(prefix+"CA_FILE", defaults.CAFile),
+			Fingerprints: getEnvListDefault(prefix+"TLS_FINGERPRINT", defaults.Fingerprints),
+			PollInterval: p.duration(prefix+"POLL_INTERVAL", defaults.PollInterval),
+			Interface:    getEnv(prefix+"INTERFACE", defaults.Interface),
+			MultiIPv4:    getEnv(prefix+"MULTI_IPV4", defaults.MultiIPv4),
+			Domain:       getEnv(prefix+"DOMAIN", defaults.Domain),
+			RecordTTL:    p.int(prefix+"RECORD_TTL", defaults.RecordTTL),
+		})
+	}
+	
+	return clusters
+}
+
+// loadViews builds the view list from VIEWS and the VIEW_<NAME>_* variables.
+// Without a target of its own, a view uses the targets of the default view.
+func loadViews() []ViewConfig {
+	var views []ViewConfig
+	for _, name := range getEnvList("VIEWS") {
+		prefix := "VIEW_" + envName(name) + "_"
+		views = append(views, ViewConfig{
+			Name:   name,
+			Prefix: strings.TrimSuffix(getEnv(prefix+"PREFIX", ""), "/"),
+			Target: getEnv(prefix+"TARGET", ""),
+		})
+	}
+	return views
+}
+
+// viewConfig returns the configuration of the EtcdClient publishing view
+func viewConfig(config Config, view ViewConfig) Config {
+	config.EtcdPrefix = view.Prefix
+	if view.Target != "" {
+		config.DNSTarget = view.Target
+		config.DomainTargets = nil
+		config.EntrypointTargets = nil
 	}
+	config.Views = nil
+	return config
+}
+
+// envName converts a free-form name into the form used inside environment variable names
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
 }
 
+// getEnv reads a setting from the environment, falling back to the config file
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value, _, found := lookupSetting(key); found {
 		return value
 	}
 	return defaultValue
 }
 
-func detectDNSTarget() string {
-	// First check if DNS_TARGET is explicitly set
-	if target := getEnv("DNS_TARGET", ""); target != "" {
-		if log != nil {
-			log.WithField("target", target).Info("Using DNS_TARGET from environment")
-		}
-		return target
+// getEnvList reads a comma-separated environment variable, ignoring empty items
+func getEnvList(key string) []string {
+	return splitList(getEnv(key, ""))
+}
+
+// getEnvListDefault reads a comma-separated environment variable, returning defaultValue when unset
+func getEnvListDefault(key string, defaultValue []string) []string {
+	if value := getEnvList(key); len(value) > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// Validate checks the configuration for values that would only fail later,
+// reporting every problem at once
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
 	}
 	
-	// Try to read hostname from mounted /etc/hostname
-	data, err := ioutil.ReadFile("/host/hostname")
-	if err != nil {
-		if log != nil {
-			log.WithError(err).Fatal("Cannot read /host/hostname - Please mount host's /etc/hostname with: -v /etc/hostname:/host/hostname:ro")
-		} else {
-			panic("FATAL: Cannot read /host/hostname - Please mount host's /etc/hostname with: -v /etc/hostname:/host/hostname:ro")
-		}
+	switch c.AgentMode {
+	case "docker", "proxmox", "hybrid":
+	default:
+		fail("invalid AGENT_MODE %q (valid options: docker, proxmox, hybrid)", c.AgentMode)
 	}
 	
-	hostname := strings.TrimSpace(string(data))
-	if hostname == "" {
-		if log != nil {
-			log.Fatal("/host/hostname is empty - Host's /etc/hostname appears to be empty")
-		} else {
-			panic("FATAL: /host/hostname is empty - Host's /etc/hostname appears to be empty")
-		}
+	if len(c.EtcdEndpoints) == 0 {
+		fail("ETCD_ENDPOINTS must list at least one endpoint")
+	}
+	if !strings.HasPrefix(c.EtcdPrefix, "/") {
+		fail("invalid ETCD_PREFIX %q: must start with /", c.EtcdPrefix)
+	}
+	if (c.EtcdCertFile == "") != (c.EtcdKeyFile == "") {
+		fail("ETCD_CERT_FILE and ETCD_KEY_FILE must be set together")
+	}
+	if (c.EtcdUsername == "") != (c.EtcdPassword == "") {
+		fail("ETCD_USERNAME and ETCD_PASSWORD must be set together")
 	}
 	
-	// Check if hostname from file is already FQDN
-	if strings.Contains(hostname, ".") {
-		if log != nil {
-			log.WithField("target", hostname).Info("Detected DNS target from /host/hostname")
+	if c.RecordTTL <= 0 {
+		fail("invalid RECORD_TTL %d: must be positive", c.RecordTTL)
+	}
+	if c.DockerRecordTTL < 0 {
+		fail("invalid DOCKER_RECORD_TTL %d: must not be negative", c.DockerRecordTTL)
+	}
+	if c.ProxmoxRecordTTL < 0 {
+		fail("invalid PROXMOX_RECORD_TTL %d: must not be negative", c.ProxmoxRecordTTL)
+	}
+	if c.WarmingTTL < 0 {
+		fail("invalid WARMING_TTL %d: must not be negative", c.WarmingTTL)
+	}
+	if c.WarmingTTL > 0 && c.WarmingPeriod <= 0 {
+		fail("invalid WARMING_PERIOD %s: must be positive", c.WarmingPeriod)
+	}
+	
+	if c.HTTPListen != "off" {
+		if _, _, err := net.SplitHostPort(c.HTTPListen); err != nil {
+			fail("invalid HTTP_LISTEN %q: %w", c.HTTPListen, err)
 		}
-		return hostname
+	}
+	if c.HealthSyncIntervals < 1 {
+		fail("invalid HEALTH_SYNC_INTERVALS %d: must be at least 1", c.HealthSyncIntervals)
 	}
 	
-	// Hostname is not FQDN, check if DOMAIN is set
-	domain := getEnv("DOMAIN", "")
-	if domain == "" {
-		if log != nil {
-			log.WithField("hostname", hostname).Fatal("Host hostname is not FQDN and DOMAIN environment variable is not set. Please set DOMAIN=your-domain.com")
-		} else {
-			panic("FATAL: Host hostname is not FQDN and DOMAIN environment variable is not set. Please set DOMAIN=your-domain.com")
+	if err := validateTargetResolvers(c.DNSTargetResolvers); err != nil {
+		fail("invalid DNS_TARGET_RESOLVERS: %w", err)
+	}
+	if _, err := parseReverseZones(c.ReverseZones); err != nil {
+		fail("invalid REVERSE_ZONES: %w", err)
+	}
+	if _, err := parseDomainTTLs(c.DomainTTLs); err != nil {
+		fail("invalid DOMAIN_TTLS: %w", err)
+	}
+	if _, err := parseDomainTargets(c.DomainTargets); err != nil {
+		fail("invalid DOMAIN_TARGETS: %w", err)
+	}
+	if _, err := parseEntrypointTargets(c.EntrypointTargets); err != nil {
+		fail("invalid ENTRYPOINT_TARGETS: %w", err)
+	}
+	if err := validateConflictPolicy(c.ConflictPolicy); err != nil {
+		fail("invalid CONFLICT_POLICY: %w", err)
+	}
+	if err := validateWatchPolicy(c.WatchPolicy); err != nil {
+		fail("invalid WATCH_POLICY: %w", err)
+	}
+	
+	if c.LeaderElectionTTL <= 0 {
+		fail("invalid LEADER_ELECTION_TTL %d: must be positive", c.LeaderElectionTTL)
+	}
+	if c.LeaderElection && strings.HasPrefix(c.LeaderElectionPrefix+"/", c.EtcdPrefix+"/") {
+		fail("invalid LEADER_ELECTION_PREFIX %q: must not be inside ETCD_PREFIX", c.LeaderElectionPrefix)
+	}
+	
+	// Prefixes must not nest, or stale record cleanup of one view would
+	// delete the records of another
+	prefixes := map[string]string{"default": c.EtcdPrefix}
+	for _, view := range c.Views {
+		if _, found := prefixes[view.Name]; found {
+			fail("duplicate view name: %s", view.Name)
+			continue
+		}
+		if !strings.HasPrefix(view.Prefix, "/") {
+			fail("invalid prefix %q for view %s: must start with /", view.Prefix, view.Name)
+			continue
+		}
+		for name, prefix := range prefixes {
+			if strings.HasPrefix(view.Prefix+"/", prefix+"/") || strings.HasPrefix(prefix+"/", view.Prefix+"/") {
+				fail("invalid prefix %q for view %s: overlaps the prefix of view %s", view.Prefix, view.Name, name)
+			}
+		}
+		if c.LeaderElection && strings.HasPrefix(c.LeaderElectionPrefix+"/", view.Prefix+"/") {
+			fail("invalid LEADER_ELECTION_PREFIX %q: must not be inside the prefix of view %s", c.LeaderElectionPrefix, view.Name)
 		}
+		prefixes[view.Name] = view.Prefix
 	}
 	
-	fqdn := hostname + "." + domain
-	if log != nil {
-		log.WithFields(map[string]interface{}{
-			"hostname": hostname,
-			"domain":   domain,
-			"fqdn":     fqdn,
-		}).Info("Detected DNS target from hostname and domain")
+	if c.AgentMode == "proxmox" || c.AgentMode == "hybrid" {
+		if _, err := NewGuestFilter(c); err != nil {
+			fail("invalid Proxmox guest filter: %w", err)
+		}
+		
+		seen := make(map[string]bool)
+		for _, cluster := range c.ProxmoxClusters {
+			if seen[cluster.Name] {
+				fail("duplicate Proxmox cluster name: %s", cluster.Name)
+			}
+			seen[cluster.Name] = true
+			
+			if len(cluster.APIURLs) == 0 {
+				fail("no API URL configured for Proxmox cluster %s", cluster.Name)
+			}
+			if _, err := proxmoxAuthOption(cluster); err != nil {
+				fail("invalid credentials for Proxmox cluster %s: %w", cluster.Name, err)
+			}
+			if cluster.PollInterval <= 0 {
+				fail("invalid poll interval %s for Proxmox cluster %s: must be positive", cluster.PollInterval, cluster.Name)
+			}
+			if cluster.MultiIPv4 != "first" && cluster.MultiIPv4 != "all" {
+				fail("invalid multi IPv4 mode %q for Proxmox cluster %s (valid options: first, all)", cluster.MultiIPv4, cluster.Name)
+			}
+			if cluster.RecordTTL < 0 {
+				fail("invalid record TTL %d for Proxmox cluster %s: must not be negative", cluster.RecordTTL, cluster.Name)
+			}
+		}
 	}
-	return fqdn
-}
\ No newline at end of file
+	
+	return errors.Join(errs...)
+}
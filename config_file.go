@@ -0,0 +1,538 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in --print-config output
+const redacted = "<redacted>"
+
+// configSetting maps a config file path onto the environment variable it sets
+type configSetting struct {
+	path   string
+	env    string
+	secret bool
+	value  func(Config) interface{}
+}
+
+// configSettings lists every setting that can be put in the config file
+var configSettings = []configSetting{
+	{path: "agent.mode", env: "AGENT_MODE", value: func(c Config) interface{} { return c.AgentMode }},
+	{path: "agent.id", env: "AGENT_ID", value: func(c Config) interface{} { return c.AgentID }},
+
+	{path: "log.level", env: "LOG_LEVEL", value: func(Config) interface{} { return getEnv("LOG_LEVEL", "info") }},
+	{path: "log.format", env: "LOG_FORMAT", value: func(Config) interface{} { return getEnv("LOG_FORMAT", "text") }},
+
+	{path: "etcd.endpoints", env: "ETCD_ENDPOINTS", value: func(c Config) interface{} { return c.EtcdEndpoints }},
+	{path: "etcd.prefix", env: "ETCD_PREFIX", value: func(c Config) interface{} { return c.EtcdPrefix }},
+	{path: "etcd.tls", env: "ETCD_TLS", value: func(c Config) interface{} { return c.EtcdTLS }},
+	{path: "etcd.cert_file", env: "ETCD_CERT_FILE", value: func(c Config) interface{} { return c.EtcdCertFile }},
+	{path: "etcd.key_file", env: "ETCD_KEY_FILE", value: func(c Config) interface{} { return c.EtcdKeyFile }},
+	{path: "etcd.ca_file", env: "ETCD_CA_FILE", value: func(c Config) interface{} { return c.EtcdCAFile }},
+	{path: "etcd.username", env: "ETCD_USERNAME", value: func(c Config) interface{} { return c.EtcdUsername }},
+	{path: "etcd.password", env: "ETCD_PASSWORD", secret: true, value: func(c Config) interface{} { return c.EtcdPassword }},
+	{path: "etcd.password_file", env: "ETCD_PASSWORD_FILE", value: func(Config) interface{} { return getEnv("ETCD_PASSWORD_FILE", "") }},
+	{path: "etcd.dry_run", env: "DRY_RUN", value: func(c Config) interface{} { return c.DryRun }},
+
+	{path: "dns.target", env: "DNS_TARGET", value: func(c Config) interface{} { return c.DNSTarget }},
+	{path: "dns.target_resolvers", env: "DNS_TARGET_RESOLVERS", value: func(c Config) interface{} { return c.DNSTargetResolvers }},
+	{path: "dns.domain_targets", env: "DOMAIN_TARGETS", value: func(c Config) interface{} { return settingMap(c.DomainTargets) }},
+	{path: "dns.domain", env: "DOMAIN", value: func(c Config) interface{} { return c.Domain }},
+	{path: "dns.record_ttl", env: "RECORD_TTL", value: func(c Config) interface{} { return c.RecordTTL }},
+	{path: "dns.domain_ttls", env: "DOMAIN_TTLS", value: func(c Config) interface{} { return settingMap(c.DomainTTLs) }},
+	{path: "dns.warming_ttl", env: "WARMING_TTL", value: func(c Config) interface{} { return c.WarmingTTL }},
+	{path: "dns.warming_period", env: "WARMING_PERIOD", value: func(c Config) interface{} { return c.WarmingPeriod.String() }},
+	{path: "dns.ptr_records", env: "PTR_RECORDS", value: func(c Config) interface{} { return c.PTRRecords }},
+	{path: "dns.reverse_zones", env: "REVERSE_ZONES", value: func(c Config) interface{} { return c.ReverseZones }},
+	{path: "dns.conflict_policy", env: "CONFLICT_POLICY", value: func(c Config) interface{} { return c.ConflictPolicy }},
+	{path: "dns.watch_policy", env: "WATCH_POLICY", value: func(c Config) interface{} { return c.WatchPolicy }},
+
+	{path: "docker.record_ttl", env: "DOCKER_RECORD_TTL", value: func(c Config) interface{} { return c.DockerRecordTTL }},
+	{path: "docker.entrypoint_targets", env: "ENTRYPOINT_TARGETS", value: func(c Config) interface{} { return settingMap(c.EntrypointTargets) }},
+
+	{path: "proxmox.api_url", env: "PROXMOX_API_URL", value: func(c Config) interface{} { return splitList(c.ProxmoxAPIURL) }},
+	{path: "proxmox.token_id", env: "PROXMOX_TOKEN_ID", value: func(c Config) interface{} { return c.ProxmoxTokenID }},
+	{path: "proxmox.token_secret", env: "PROXMOX_TOKEN_SECRET", secret: true, value: func(c Config) interface{} { return c.ProxmoxTokenSecret }},
+	{path: "proxmox.token_secret_file", env: "PROXMOX_TOKEN_SECRET_FILE", value: func(Config) interface{} { return getEnv("PROXMOX_TOKEN_SECRET_FILE", "") }},
+	{path: "proxmox.username", env: "PROXMOX_USERNAME", value: func(c Config) interface{} { return c.ProxmoxUsername }},
+	{path: "proxmox.password", env: "PROXMOX_PASSWORD", secret: true, value: func(c Config) interface{} { return c.ProxmoxPassword }},
+	{path: "proxmox.password_file", env: "PROXMOX_PASSWORD_FILE", value: func(Config) interface{} { return getEnv("PROXMOX_PASSWORD_FILE", "") }},
+	{path: "proxmox.realm", env: "PROXMOX_REALM", value: func(c Config) interface{} { return c.ProxmoxRealm }},
+	{path: "proxmox.verify_ssl", env: "PROXMOX_VERIFY_SSL", value: func(c Config) interface{} { return c.ProxmoxVerifySSL }},
+	{path: "proxmox.ca_file", env: "PROXMOX_CA_FILE", value: func(c Config) interface{} { return c.ProxmoxCAFile }},
+	{path: "proxmox.tls_fingerprint", env: "PROXMOX_TLS_FINGERPRINT", value: func(c Config) interface{} { return c.ProxmoxFingerprints }},
+	{path: "proxmox.poll_interval", env: "PROXMOX_POLL_INTERVAL", value: func(c Config) interface{} { return c.ProxmoxPollInterval.String() }},
+	{path: "proxmox.interface", env: "PROXMOX_INTERFACE", value: func(c Config) interface{} { return c.ProxmoxInterface }},
+	{path: "proxmox.multi_ipv4", env: "PROXMOX_MULTI_IPV4", value: func(c Config) interface{} { return c.ProxmoxMultiIPv4 }},
+	{path: "proxmox.record_ttl", env: "PROXMOX_RECORD_TTL", value: func(c Config) interface{} { return c.ProxmoxRecordTTL }},
+
+	{path: "proxmox.opt_in", env: "PROXMOX_OPT_IN", value: func(c Config) interface{} { return c.ProxmoxOptIn }},
+	{path: "proxmox.opt_in_tag", env: "PROXMOX_OPT_IN_TAG", value: func(c Config) interface{} { return c.ProxmoxOptInTag }},
+	{path: "proxmox.include_pools", env: "PROXMOX_INCLUDE_POOLS", value: func(c Config) interface{} { return c.ProxmoxIncludePools }},
+	{path: "proxmox.exclude_pools", env: "PROXMOX_EXCLUDE_POOLS", value: func(c Config) interface{} { return c.ProxmoxExcludePools }},
+	{path: "proxmox.include_nodes", env: "PROXMOX_INCLUDE_NODES", value: func(c Config) interface{} { return c.ProxmoxIncludeNodes }},
+	{path: "proxmox.exclude_nodes", env: "PROXMOX_EXCLUDE_NODES", value: func(c Config) interface{} { return c.ProxmoxExcludeNodes }},
+	{path: "proxmox.include_vmids", env: "PROXMOX_INCLUDE_VMIDS", value: func(c Config) interface{} { return c.ProxmoxIncludeVMIDs }},
+	{path: "proxmox.exclude_vmids", env: "PROXMOX_EXCLUDE_VMIDS", value: func(c Config) interface{} { return c.ProxmoxExcludeVMIDs }},
+	{path: "proxmox.include_name", env: "PROXMOX_INCLUDE_NAME", value: func(c Config) interface{} { return c.ProxmoxIncludeName }},
+	{path: "proxmox.exclude_name", env: "PROXMOX_EXCLUDE_NAME", value: func(c Config) interface{} { return c.ProxmoxExcludeName }},
+	{path: "proxmox.include_tags", env: "PROXMOX_INCLUDE_TAGS", value: func(c Config) interface{} { return c.ProxmoxIncludeTags }},
+	{path: "proxmox.exclude_tags", env: "PROXMOX_EXCLUDE_TAGS", value: func(c Config) interface{} { return c.ProxmoxExcludeTags }},
+
+	{path: "election.enabled", env: "LEADER_ELECTION", value: func(c Config) interface{} { return c.LeaderElection }},
+	{path: "election.prefix", env: "LEADER_ELECTION_PREFIX", value: func(c Config) interface{} { return c.LeaderElectionPrefix }},
+	{path: "election.ttl", env: "LEADER_ELECTION_TTL", value: func(c Config) interface{} { return c.LeaderElectionTTL }},
+
+	{path: "http.listen", env: "HTTP_LISTEN", value: func(c Config) interface{} { return c.HTTPListen }},
+	{path: "http.health_sync_intervals", env: "HEALTH_SYNC_INTERVALS", value: func(c Config) interface{} { return c.HealthSyncIntervals }},
+}
+
+// clusterSetting is a per-cluster setting, stored as PROXMOX_<NAME>_<KEY>
+type clusterSetting struct {
+	key    string
+	secret bool
+	value  func(ProxmoxClusterConfig) interface{}
+}
+
+// clusterSettings lists the settings of a proxmox.clusters entry
+var clusterSettings = []clusterSetting{
+	{key: "api_url", value: func(c ProxmoxClusterConfig) interface{} { return c.APIURLs }},
+	{key: "token_id", value: func(c ProxmoxClusterConfig) interface{} { return c.TokenID }},
+	{key: "token_secret", secret: true, value: func(c ProxmoxClusterConfig) interface{} { return c.TokenSecret }},
+	{key: "token_secret_file", value: func(c ProxmoxClusterConfig) interface{} { return clusterEnv(c, "TOKEN_SECRET_FILE") }},
+	{key: "username", value: func(c ProxmoxClusterConfig) interface{} { return c.Username }},
+	{key: "password", secret: true, value: func(c ProxmoxClusterConfig) interface{} { return c.Password }},
+	{key: "password_file", value: func(c ProxmoxClusterConfig) interface{} { return clusterEnv(c, "PASSWORD_FILE") }},
+	{key: "realm", value: func(c ProxmoxClusterConfig) interface{} { return c.Realm }},
+	{key: "verify_ssl", value: func(c ProxmoxClusterConfig) interface{} { return c.VerifySSL }},
+	{key: "ca_file", value: func(c ProxmoxClusterConfig) interface{} { return c.CAFile }},
+	{key: "tls_fingerprint", value: func(c ProxmoxClusterConfig) interface{} { return c.Fingerprints }},
+	{key: "poll_interval", value: func(c ProxmoxClusterConfig) interface{} { return c.PollInterval.String() }},
+	{key: "interface", value: func(c ProxmoxClusterConfig) interface{} { return c.Interface }},
+	{key: "multi_ipv4", value: func(c ProxmoxClusterConfig) interface{} { return c.MultiIPv4 }},
+	{key: "domain", value: func(c ProxmoxClusterConfig) interface{} { return c.Domain }},
+	{key: "record_ttl", value: func(c ProxmoxClusterConfig) interface{} { return c.RecordTTL }},
+}
+
+// clusterEnv reads the PROXMOX_<NAME>_<key> setting of cluster
+func clusterEnv(cluster ProxmoxClusterConfig, key string) string {
+	return getEnv("PROXMOX_"+envName(cluster.Name)+"_"+key, "")
+}
+
+// fileSettings holds the values read from the config file, keyed by
+// environment variable name, and fileOrigins where each of them came from
+var (
+	fileSettings = map[string]string{}
+	fileOrigins  = map[string]string{}
+)
+
+// lookupSetting returns a setting from the environment or the config file,
+// together with a description of where it was found
+func lookupSetting(key string) (string, string, bool) {
+	if value := os.Getenv(key); value != "" {
+		return value, key, true
+	}
+	if value, found := fileSettings[key]; found && value != "" {
+		return value, fileOrigins[key], true
+	}
+	return "", "", false
+}
+
+// loadConfigFile reads a YAML or TOML config file. Every setting in it is
+// validated against the known settings; environment variables still take
+// precedence over its values. An empty path loads nothing.
+func loadConfigFile(path string) error {
+	settings, origins, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	fileSettings, fileOrigins = settings, origins
+	return nil
+}
+
+// readConfigFile parses a config file into settings keyed by environment
+// variable name and their origins
+func readConfigFile(path string) (map[string]string, map[string]string, error) {
+	settings := map[string]string{}
+	origins := map[string]string{}
+	if path == "" {
+		return settings, origins, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		_, err = toml.Decode(string(data), &doc)
+	default:
+		return nil, nil, fmt.Errorf("unsupported config file %s: use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if err := flattenConfig(doc, path, settings, origins); err != nil {
+		return nil, nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return settings, origins, nil
+}
+
+// flattenConfig converts the config file document into environment variable
+// names and values, rejecting unknown settings
+func flattenConfig(doc map[string]interface{}, path string, settings, origins map[string]string) error {
+	known := make(map[string]string, len(configSettings))
+	for _, setting := range configSettings {
+		known[setting.path] = setting.env
+	}
+
+	var errs []string
+	var walk func(prefix string, node map[string]interface{})
+	walk = func(prefix string, node map[string]interface{}) {
+		for key, value := range node {
+			name := prefix + key
+			if name == "proxmox.clusters" {
+				if err := flattenClusters(value, path, settings, origins); err != nil {
+					errs = append(errs, err.Error())
+				}
+				continue
+			}
+			if name == "views" {
+				if err := flattenViews(value, path, settings, origins); err != nil {
+					errs = append(errs, err.Error())
+				}
+				continue
+			}
+
+			if env, found := known[name]; found {
+				text, err := settingText(value)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+					continue
+				}
+				settings[env] = text
+				origins[env] = fmt.Sprintf("%s in %s", name, path)
+				continue
+			}
+
+			if section, ok := value.(map[string]interface{}); ok {
+				walk(name+".", section)
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("unknown setting %s", name))
+		}
+	}
+	walk("", doc)
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// flattenClusters converts the proxmox.clusters list into PROXMOX_CLUSTERS
+// and PROXMOX_<NAME>_* settings
+func flattenClusters(value interface{}, path string, settings, origins map[string]string) error {
+	entries, err := sectionList(value, "proxmox.clusters", "cluster")
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool, len(clusterSettings))
+	for _, setting := range clusterSettings {
+		known[setting.key] = true
+	}
+
+	var names []string
+	for i, cluster := range entries {
+		name, _ := cluster["name"].(string)
+		if name == "" {
+			return fmt.Errorf("proxmox.clusters[%d]: name is required", i)
+		}
+		names = append(names, name)
+
+		for key, value := range cluster {
+			if key == "name" {
+				continue
+			}
+			if !known[key] {
+				return fmt.Errorf("proxmox.clusters[%s]: unknown setting %s", name, key)
+			}
+			text, err := settingText(value)
+			if err != nil {
+				return fmt.Errorf("proxmox.clusters[%s].%s: %w", name, key, err)
+			}
+			env := "PROXMOX_" + envName(name) + "_" + strings.ToUpper(key)
+			settings[env] = text
+			origins[env] = fmt.Sprintf("proxmox.clusters[%s].%s in %s", name, key, path)
+		}
+	}
+
+	settings["PROXMOX_CLUSTERS"] = strings.Join(names, ",")
+	origins["PROXMOX_CLUSTERS"] = fmt.Sprintf("proxmox.clusters in %s", path)
+	return nil
+}
+
+// flattenViews converts the views list into VIEWS and VIEW_<NAME>_* settings
+func flattenViews(value interface{}, path string, settings, origins map[string]string) error {
+	entries, err := sectionList(value, "views", "view")
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for i, view := range entries {
+		name, _ := view["name"].(string)
+		if name == "" {
+			return fmt.Errorf("views[%d]: name is required", i)
+		}
+		names = append(names, name)
+
+		for key, value := range view {
+			if key == "name" {
+				continue
+			}
+			if key != "prefix" && key != "target" {
+				return fmt.Errorf("views[%s]: unknown setting %s", name, key)
+			}
+			text, err := settingText(value)
+			if err != nil {
+				return fmt.Errorf("views[%s].%s: %w", name, key, err)
+			}
+			env := "VIEW_" + envName(name) + "_" + strings.ToUpper(key)
+			settings[env] = text
+			origins[env] = fmt.Sprintf("views[%s].%s in %s", name, key, path)
+		}
+	}
+
+	settings["VIEWS"] = strings.Join(names, ",")
+	origins["VIEWS"] = fmt.Sprintf("views in %s", path)
+	return nil
+}
+
+// sectionList returns the entries of a list of sections. YAML decodes it into
+// a list of values, TOML arrays of tables into a list of maps.
+func sectionList(value interface{}, name, item string) ([]map[string]interface{}, error) {
+	switch entries := value.(type) {
+	case []map[string]interface{}:
+		return entries, nil
+	case []interface{}:
+		sections := make([]map[string]interface{}, 0, len(entries))
+		for i, entry := range entries {
+			section, ok := entry.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s[%d]: expected a %s section", name, i, item)
+			}
+			sections = append(sections, section)
+		}
+		return sections, nil
+	default:
+		return nil, fmt.Errorf("%s: expected a list of %ss", name, item)
+	}
+}
+
+// settingText converts a config file value into its environment variable form:
+// lists become comma-separated and maps become comma-separated key=value pairs
+func settingText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			text, err := settingText(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, fmt.Sprintf("%s=%v", key, v[key]))
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// settingMap converts key=value entries back into a map for printing
+func settingMap(entries []string) map[string]string {
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		key, value, _ := strings.Cut(entry, "=")
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// PrintConfig writes the effective configuration as a YAML config file, with
+// secrets redacted
+func PrintConfig(w io.Writer, config Config) error {
+	doc := map[string]interface{}{}
+	for _, setting := range configSettings {
+		value := setting.value(config)
+		if setting.secret && value != "" {
+			value = redacted
+		}
+
+		section := doc
+		parts := strings.Split(setting.path, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := section[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				section[part] = next
+			}
+			section = next
+		}
+		section[parts[len(parts)-1]] = value
+	}
+
+	var clusters []map[string]interface{}
+	for _, cluster := range config.ProxmoxClusters {
+		entry := map[string]interface{}{"name": cluster.Name}
+		for _, setting := range clusterSettings {
+			value := setting.value(cluster)
+			if setting.secret && value != "" {
+				value = redacted
+			}
+			entry[setting.key] = value
+		}
+		clusters = append(clusters, entry)
+	}
+	if len(clusters) > 0 {
+		doc["proxmox"].(map[string]interface{})["clusters"] = clusters
+	}
+
+	var views []map[string]interface{}
+	for _, view := range config.Views {
+		views = append(views, map[string]interface{}{
+			"name":   view.Name,
+			"prefix": view.Prefix,
+			"target": view.Target,
+		})
+	}
+	if len(views) > 0 {
+		doc["views"] = views
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// configParser reads typed settings and collects every malformed value
+type configParser struct {
+	errs []error
+
+	// files lists the secret files read
+	files []string
+}
+
+func (p *configParser) fail(origin, kind, value string, err error) {
+	p.errs = append(p.errs, fmt.Errorf("%s: invalid %s %q: %v", origin, kind, value, err))
+}
+
+func (p *configParser) bool(key string, defaultValue bool) bool {
+	value, origin, found := lookupSetting(key)
+	if !found {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(origin, "boolean", value, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (p *configParser) int(key string, defaultValue int) int {
+	value, origin, found := lookupSetting(key)
+	if !found {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		p.fail(origin, "number", value, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (p *configParser) duration(key string, defaultValue time.Duration) time.Duration {
+	value, origin, found := lookupSetting(key)
+	if !found {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		p.fail(origin, "duration", value, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (p *configParser) list(key string, defaultValue []string) []string {
+	return getEnvListDefault(key, defaultValue)
+}
+
+// secret reads a secret from key or from the file named by key_FILE (as
+// mounted by Docker or Kubernetes secrets). Either one set in the environment
+// overrides both in the config file.
+func (p *configParser) secret(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return p.readSecret(path, key+"_FILE", defaultValue)
+	}
+	if value, found := fileSettings[key]; found && value != "" {
+		return value
+	}
+	if path, found := fileSettings[key+"_FILE"]; found && path != "" {
+		return p.readSecret(path, fileOrigins[key+"_FILE"], defaultValue)
+	}
+	return defaultValue
+}
+
+// readSecret reads the secret file at path, set by origin, and records it so
+// that it is watched for changes
+func (p *configParser) readSecret(path, origin, defaultValue string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: failed to read secret: %w", origin, err))
+		return defaultValue
+	}
+	p.files = append(p.files, path)
+	return strings.TrimRight(string(data), "\r\n")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: `
+agent:
+  mode: hybrid
+dns:
+  record_ttl: 60
+  domain_ttls:
+    lab.example.com: 30
+proxmox:
+  clusters:
+    - name: lab
+      api_url: [https://pve1:8006, https://pve2:8006]
+    - name: prod
+      record_ttl: 3600
+views:
+  - name: external
+    prefix: /skydns-external
+    target: edge.example.com
+`,
+			want: map[string]string{
+				"AGENT_MODE":              "hybrid",
+				"RECORD_TTL":              "60",
+				"DOMAIN_TTLS":             "lab.example.com=30",
+				"PROXMOX_CLUSTERS":        "lab,prod",
+				"PROXMOX_LAB_API_URL":     "https://pve1:8006,https://pve2:8006",
+				"PROXMOX_PROD_RECORD_TTL": "3600",
+				"VIEWS":                   "external",
+				"VIEW_EXTERNAL_PREFIX":    "/skydns-external",
+				"VIEW_EXTERNAL_TARGET":    "edge.example.com",
+			},
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			data: `
+[agent]
+mode = "hybrid"
+
+[dns]
+record_ttl = 60
+
+[dns.domain_ttls]
+"lab.example.com" = 30
+
+[[proxmox.clusters]]
+name = "lab"
+api_url = ["https://pve1:8006", "https://pve2:8006"]
+
+[[proxmox.clusters]]
+name = "prod"
+record_ttl = 3600
+
+[[views]]
+name = "external"
+prefix = "/skydns-external"
+target = "edge.example.com"
+`,
+			want: map[string]string{
+				"AGENT_MODE":              "hybrid",
+				"RECORD_TTL":              "60",
+				"DOMAIN_TTLS":             "lab.example.com=30",
+				"PROXMOX_CLUSTERS":        "lab,prod",
+				"PROXMOX_LAB_API_URL":     "https://pve1:8006,https://pve2:8006",
+				"PROXMOX_PROD_RECORD_TTL": "3600",
+				"VIEWS":                   "external",
+				"VIEW_EXTERNAL_PREFIX":    "/skydns-external",
+				"VIEW_EXTERNAL_TARGET":    "edge.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			settings, origins, err := readConfigFile(path)
+			if err != nil {
+				t.Fatalf("readConfigFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(settings, tt.want) {
+				t.Errorf("readConfigFile() settings = %v, want %v", settings, tt.want)
+			}
+			if origin := origins["PROXMOX_LAB_API_URL"]; !strings.HasSuffix(origin, " in "+path) {
+				t.Errorf("origin of PROXMOX_LAB_API_URL = %q", origin)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want string
+	}{
+		{"unknown setting", "config.yaml", "dns:\n  ttl: 60\n", "unknown setting dns.ttl"},
+		{"unknown cluster setting", "config.yaml", "proxmox:\n  clusters:\n    - name: lab\n      url: x\n", "proxmox.clusters[lab]: unknown setting url"},
+		{"cluster without name", "config.toml", "[[proxmox.clusters]]\napi_url = \"x\"\n", "proxmox.clusters[0]: name is required"},
+		{"clusters not a list", "config.yaml", "proxmox:\n  clusters: lab\n", "expected a list of clusters"},
+		{"unknown view setting", "config.toml", "[[views]]\nname = \"external\"\nzone = \"x\"\n", "views[external]: unknown setting zone"},
+		{"views not a list", "config.yaml", "views: external\n", "expected a list of views"},
+		{"unsupported extension", "config.json", "{}", "unsupported config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err := readConfigFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readConfigFile() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlattenConfig(t *testing.T) {
+	doc := map[string]interface{}{
+		"etcd": map[string]interface{}{
+			"endpoints": []interface{}{"10.0.0.1:2379", "10.0.0.2:2379"},
+			"tls":       true,
+		},
+		"dns": map[string]interface{}{
+			"domain_targets": map[string]interface{}{"b.example.com": "edge-b", "a.example.com": "edge-a"},
+			"record_ttl":     int64(60),
+		},
+	}
+
+	settings := map[string]string{}
+	origins := map[string]string{}
+	if err := flattenConfig(doc, "config.yaml", settings, origins); err != nil {
+		t.Fatalf("flattenConfig() error = %v", err)
+	}
+
+	want := map[string]string{
+		"ETCD_ENDPOINTS": "10.0.0.1:2379,10.0.0.2:2379",
+		"ETCD_TLS":       "true",
+		"DOMAIN_TARGETS": "a.example.com=edge-a,b.example.com=edge-b",
+		"RECORD_TTL":     "60",
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("flattenConfig() settings = %v, want %v", settings, want)
+	}
+	if origin := origins["ETCD_TLS"]; origin != "etcd.tls in config.yaml" {
+		t.Errorf("origin of ETCD_TLS = %q", origin)
+	}
+}
+
+func TestFlattenConfigErrors(t *testing.T) {
+	doc := map[string]interface{}{
+		"etcd":  map[string]interface{}{"hosts": "x"},
+		"extra": "x",
+		"dns":   map[string]interface{}{"record_ttl": []interface{}{map[string]interface{}{}, struct{}{}}},
+	}
+
+	err := flattenConfig(doc, "config.yaml", map[string]string{}, map[string]string{})
+	want := "dns.record_ttl: unsupported value {}; unknown setting etcd.hosts; unknown setting extra"
+	if err == nil || err.Error() != want {
+		t.Errorf("flattenConfig() error = %v, want %q", err, want)
+	}
+}
+
+func TestConfigParserSecret(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env-secret")
+	configFile := filepath.Join(dir, "file-secret")
+	if err := os.WriteFile(envFile, []byte("from-env-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte("from-config-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		file map[string]string
+		want string
+	}{
+		{"unset", nil, nil, "default"},
+		{"env", map[string]string{"TEST_SECRET": "from-env"}, map[string]string{"TEST_SECRET": "from-config"}, "from-env"},
+		{"env file over config value", map[string]string{"TEST_SECRET_FILE": envFile}, map[string]string{"TEST_SECRET": "from-config"}, "from-env-file"},
+		{"config value", nil, map[string]string{"TEST_SECRET": "from-config", "TEST_SECRET_FILE": configFile}, "from-config"},
+		{"config file", nil, map[string]string{"TEST_SECRET_FILE": configFile}, "from-config-file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_SECRET", "")
+			t.Setenv("TEST_SECRET_FILE", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			previous, previousOrigins := fileSettings, fileOrigins
+			fileSettings, fileOrigins = tt.file, map[string]string{}
+			defer func() { fileSettings, fileOrigins = previous, previousOrigins }()
+
+			p := &configParser{}
+			if got := p.secret("TEST_SECRET", "default"); got != tt.want {
+				t.Errorf("secret() = %q, want %q", got, tt.want)
+			}
+			if len(p.errs) > 0 {
+				t.Errorf("secret() errors = %v", p.errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Conflict policies applied when a name already holds records of another owner
+const (
+	ConflictRefuse   = "refuse"
+	ConflictTakeover = "takeover"
+	ConflictMerge    = "merge"
+)
+
+// errConflictRefused is returned for a name that was not written because
+// CONFLICT_POLICY left it to the records of another owner
+var errConflictRefused = errors.New("refused by CONFLICT_POLICY")
+
+// validateConflictPolicy checks CONFLICT_POLICY
+func validateConflictPolicy(policy string) error {
+	switch policy {
+	case ConflictRefuse, ConflictTakeover, ConflictMerge:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q (valid options: %s, %s, %s)", policy, ConflictRefuse, ConflictTakeover, ConflictMerge)
+}
+
+// recordConflict is a record of another owner found at a name about to be written
+type recordConflict struct {
+	key        string
+	owner      string
+	recordType string
+
+	// kind is "type" for a CNAME next to other records and "owner" otherwise
+	kind string
+
+	// mergeable is set when both records can be served side by side
+	mergeable bool
+}
+
+// addressType returns the type of an A, AAAA or CNAME record, or "" for other records
+func addressType(record DNSRecord) string {
+	if record.Host == "" || record.Port != 0 || record.Text != "" {
+		return ""
+	}
+	ip := net.ParseIP(record.Host)
+	switch {
+	case ip == nil:
+		return "CNAME"
+	case ip.To4() != nil:
+		return "A"
+	default:
+		return "AAAA"
+	}
+}
+
+// findConflicts lists the A/AAAA/CNAME records of other owners stored at a
+// name. keys are the keys about to be written, types the type of each record.
+func findConflicts(stored []storedRecord, keys, types []string, owner string) []recordConflict {
+	writing := make(map[string]bool)
+	writingLeaf := false
+	writingCNAME := false
+	for i, key := range keys {
+		writing[key] = true
+		if types[i] == "CNAME" {
+			writingCNAME = true
+		}
+	}
+	for _, entry := range stored {
+		if entry.leaf && writing[entry.key] {
+			writingLeaf = true
+		}
+	}
+
+	var conflicts []recordConflict
+	for _, entry := range stored {
+		if !entry.valid {
+			continue
+		}
+		existingType := addressType(entry.record)
+		if existingType == "" || entry.record.Owner == owner || adoptsLegacy(entry, writing, owner) {
+			continue
+		}
+
+		conflict := recordConflict{
+			key:        entry.key,
+			owner:      entry.record.Owner,
+			recordType: existingType,
+			kind:       "owner",
+		}
+		if existingType == "CNAME" || writingCNAME {
+			conflict.kind = "type"
+		} else {
+			// A leaf record is hidden by sub-keys of the same name, so
+			// only distinct sub-keys can be served together
+			conflict.mergeable = !writing[entry.key] && !entry.leaf && !writingLeaf
+		}
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts
+}
+
+// adoptsLegacy reports whether owner takes over an address record that an
+// older DNSherpa version stored without an owner: one at a key about to be
+// written, a numbered record next to the numbered records being written, or
+// for a Docker owner the record on the name itself, where Docker records
+// were stored before they moved to per-agent sub-keys
+func adoptsLegacy(entry storedRecord, writing map[string]bool, owner string) bool {
+	if entry.record.Owner != "" {
+		return false
+	}
+	if writing[entry.key] {
+		return true
+	}
+	if entry.leaf {
+		return strings.HasPrefix(owner, "docker/")
+	}
+	if !recordIndexKey.MatchString(entry.key[strings.LastIndex(entry.key, "/")+1:]) {
+		return false
+	}
+	for key := range writing {
+		if recordIndexKey.MatchString(key[strings.LastIndex(key, "/")+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinTypes lists the distinct record types being written, for logging
+func joinTypes(types []string) string {
+	var distinct []string
+	for _, recordType := range types {
+		if !containsString(distinct, recordType) {
+			distinct = append(distinct, recordType)
+		}
+	}
+	return strings.Join(distinct, ",")
+}
+
+// resolveConflicts applies CONFLICT_POLICY to the records stored at hostname
+// before records of types are written to keys. It reports whether the write
+// may go ahead and which conflicting keys have to be deleted with it.
+func (ec *EtcdClient) resolveConflicts(hostname string, stored []storedRecord, keys, types []string, owner string) (bool, []string) {
+	conflicts := findConflicts(stored, keys, types, owner)
+	if len(conflicts) == 0 {
+		return true, nil
+	}
+
+	policy := ec.settings().ConflictPolicy
+	proceed := true
+	for _, conflict := range conflicts {
+		if policy == ConflictRefuse || (policy == ConflictMerge && !conflict.mergeable) {
+			proceed = false
+		}
+	}
+
+	action := "merged"
+	if !proceed {
+		action = "refused"
+	} else if policy == ConflictTakeover {
+		action = "took over"
+	}
+
+	writing := make(map[string]bool)
+	for _, key := range keys {
+		writing[key] = true
+	}
+
+	var takeover []string
+	for _, conflict := range conflicts {
+		ec.countConflict(conflict.kind, action)
+
+		// Merging is the normal case for agents sharing a name, keep it quiet
+		entry := log.WithFields(map[string]interface{}{
+			"hostname":       hostname,
+			"key":            conflict.key,
+			"type":           joinTypes(types),
+			"owner":          owner,
+			"existing_type":  conflict.recordType,
+			"existing_owner": conflict.owner,
+			"conflict":       conflict.kind,
+			"policy":         policy,
+			"action":         action,
+		})
+		if action == "merged" {
+			entry.Info("DNS record conflict")
+		} else {
+			entry.Warn("DNS record conflict")
+		}
+
+		// Keys that are written anyway are simply overwritten
+		if action == "took over" && !writing[conflict.key] {
+			takeover = append(takeover, conflict.key)
+		}
+	}
+
+	return proceed, takeover
+}
+
+// countConflict counts a conflict by kind and action
+func (ec *EtcdClient) countConflict(kind, action string) {
+	ec.statsMu.Lock()
+	defer ec.statsMu.Unlock()
+
+	ec.conflicts[kind+"/"+action]++
+}
+
+// ConflictCounts returns the number of conflicts seen, keyed by "kind/action"
+func (ec *EtcdClient) ConflictCounts() map[string]int {
+	ec.statsMu.Lock()
+	defer ec.statsMu.Unlock()
+
+	counts := make(map[string]int, len(ec.conflicts))
+	for key, count := range ec.conflicts {
+		counts[key] = count
+	}
+	return counts
+}
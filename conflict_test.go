@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testName = "/skydns/com/example/web"
+
+func TestFindConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored []storedRecord
+		keys   []string
+		types  []string
+		owner  string
+		want   []recordConflict
+	}{
+		{
+			name:   "own records",
+			stored: []storedRecord{{key: testName + "/a1", valid: true, record: DNSRecord{Host: "10.0.0.1", Owner: "proxmox/lab"}}},
+			keys:   []string{testName + "/a1"},
+			types:  []string{"A"},
+			owner:  "proxmox/lab",
+		},
+		{
+			name: "other agent under its own sub-key",
+			stored: []storedRecord{
+				{key: testName + "/docker-2", valid: true, record: DNSRecord{Host: "10.0.0.2", Owner: "docker/docker-2"}},
+			},
+			keys:  []string{testName + "/docker-1"},
+			types: []string{"A"},
+			owner: "docker/docker-1",
+			want: []recordConflict{
+				{key: testName + "/docker-2", owner: "docker/docker-2", recordType: "A", kind: "owner", mergeable: true},
+			},
+		},
+		{
+			name: "same key",
+			stored: []storedRecord{
+				{key: testName + "/a1", valid: true, record: DNSRecord{Host: "10.0.0.9", Owner: "proxmox/other"}},
+			},
+			keys:  []string{testName + "/a1", testName + "/aaaa1"},
+			types: []string{"A", "AAAA"},
+			owner: "proxmox/lab",
+			want: []recordConflict{
+				{key: testName + "/a1", owner: "proxmox/other", recordType: "A", kind: "owner"},
+			},
+		},
+		{
+			name: "cname next to an address",
+			stored: []storedRecord{
+				{key: testName + "/docker-2", valid: true, record: DNSRecord{Host: "10.0.0.2", Owner: "docker/docker-2"}},
+			},
+			keys:  []string{testName + "/docker-1"},
+			types: []string{"CNAME"},
+			owner: "docker/docker-1",
+			want: []recordConflict{
+				{key: testName + "/docker-2", owner: "docker/docker-2", recordType: "A", kind: "type"},
+			},
+		},
+		{
+			name: "records of older versions are adopted",
+			stored: []storedRecord{
+				{key: testName + "/a1", valid: true, record: DNSRecord{Host: "10.0.0.1"}},
+				{key: testName + "/a2", valid: true, record: DNSRecord{Host: "10.0.0.2"}},
+			},
+			keys:  []string{testName + "/a1"},
+			types: []string{"A"},
+			owner: "proxmox/lab",
+		},
+		{
+			name: "docker record of an older version on the name",
+			stored: []storedRecord{
+				{key: testName, leaf: true, valid: true, record: DNSRecord{Host: "traefik.example.com"}},
+			},
+			keys:  []string{testName + "/docker-1"},
+			types: []string{"CNAME"},
+			owner: "docker/docker-1",
+		},
+		{
+			name: "manual record on the name",
+			stored: []storedRecord{
+				{key: testName, leaf: true, valid: true, record: DNSRecord{Host: "10.0.0.5"}},
+			},
+			keys:  []string{testName + "/a1"},
+			types: []string{"A"},
+			owner: "proxmox/lab",
+			want: []recordConflict{
+				{key: testName, recordType: "A", kind: "owner"},
+			},
+		},
+		{
+			name: "manual record under another sub-key",
+			stored: []storedRecord{
+				{key: testName + "/backup", valid: true, record: DNSRecord{Host: "10.0.0.5"}},
+			},
+			keys:  []string{testName + "/a1"},
+			types: []string{"A"},
+			owner: "proxmox/lab",
+			want: []recordConflict{
+				{key: testName + "/backup", recordType: "A", kind: "owner", mergeable: true},
+			},
+		},
+		{
+			name: "other records are ignored",
+			stored: []storedRecord{
+				{key: testName + "/srv-http", valid: true, record: DNSRecord{Host: "web.example.com", Port: 80, Owner: "proxmox/other"}},
+				{key: testName + "/txt1", valid: true, record: DNSRecord{Text: "v=spf1 -all", Owner: "proxmox/other"}},
+				{key: testName + "/raw"},
+			},
+			keys:  []string{testName + "/a1"},
+			types: []string{"A"},
+			owner: "proxmox/lab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findConflicts(tt.stored, tt.keys, tt.types, tt.owner)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findConflicts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	stored := []storedRecord{
+		{key: testName + "/docker-2", valid: true, record: DNSRecord{Host: "10.0.0.2", Owner: "docker/docker-2"}},
+		{key: testName + "/docker-1", valid: true, record: DNSRecord{Host: "10.0.0.9", Owner: "docker/docker-3"}},
+	}
+	keys := []string{testName + "/docker-1"}
+
+	tests := []struct {
+		name     string
+		policy   string
+		stored   []storedRecord
+		proceed  bool
+		takeover []string
+	}{
+		{name: "merge", policy: ConflictMerge, stored: stored[:1], proceed: true},
+		{name: "merge same key", policy: ConflictMerge, stored: stored, proceed: false},
+		{name: "refuse", policy: ConflictRefuse, stored: stored[:1], proceed: false},
+		{name: "takeover", policy: ConflictTakeover, stored: stored, proceed: true, takeover: []string{testName + "/docker-2"}},
+		{name: "refuse keeps adopting old records", policy: ConflictRefuse, stored: []storedRecord{
+			{key: testName, leaf: true, valid: true, record: DNSRecord{Host: "traefik.example.com"}},
+		}, proceed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ec := &EtcdClient{
+				current:   &etcdSettings{Config: Config{ConflictPolicy: tt.policy}},
+				conflicts: make(map[string]int),
+			}
+			proceed, takeover := ec.resolveConflicts("web.example.com", tt.stored, keys, []string{"A"}, "docker/docker-1")
+			if proceed != tt.proceed || !reflect.DeepEqual(takeover, tt.takeover) {
+				t.Errorf("resolveConflicts() = %v, %v, want %v, %v", proceed, takeover, tt.proceed, tt.takeover)
+			}
+		})
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
@@ -15,23 +20,73 @@ import (
 type DockerClient struct {
 	client    *client.Client
 	etcdClient *EtcdClient
+	
+	// views are where container records are published, the default view first
+	views []*dockerView
+	
+	// mu guards the published keys and containerNames, which the status API
+	// reads while the event loop updates them
+	mu             sync.Mutex
+	containerNames map[string]string
+	
+	// syncs records the outcome of container syncs
+	syncs syncTracker
+	
+	// connected is set while the event stream is being read
+	connected atomic.Bool
+	
+	// resync asks the event loop to republish all containers
+	resync chan struct{}
+}
+
+// dockerView tracks the container records published in one view
+type dockerView struct {
+	name       string
+	etcdClient *EtcdClient
+	
+	// containerKeys tracks the keys published for each running container
+	containerKeys map[string][]string
+}
+
+// containerRecords are the records a container asks for in its labels
+type containerRecords struct {
+	hosts       []string
+	wildcards   []string
+	services    []SRVService
+	txt         []TXTRecord
+	entrypoints map[string][]string
+	ttl         int
 }
 
-func NewDockerClient(etcdClient *EtcdClient) (*DockerClient, error) {
+func NewDockerClient(etcdClient *EtcdClient, views []View) (*DockerClient, error) {
 	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
 
+	dockerViews := []*dockerView{{name: defaultView, etcdClient: etcdClient, containerKeys: make(map[string][]string)}}
+	for _, view := range views {
+		dockerViews = append(dockerViews, &dockerView{name: view.Name, etcdClient: view.EtcdClient, containerKeys: make(map[string][]string)})
+	}
+
 	return &DockerClient{
-		client:     dockerClient,
-		etcdClient: etcdClient,
+		client:         dockerClient,
+		etcdClient:     etcdClient,
+		views:          dockerViews,
+		containerNames: make(map[string]string),
+		syncs:          syncTracker{status: SyncStatus{Source: "docker"}},
+		resync:         make(chan struct{}, 1),
 	}, nil
 }
 
+// Host and HostRegexp matchers of Traefik router rules
+var (
+	hostRegex       = regexp.MustCompile(`Host\(\s*\x60([^` + "`" + `]+)\x60\s*\)`)
+	hostRegexpRegex = regexp.MustCompile(`HostRegexp\(\s*\x60([^` + "`" + `]+)\x60\s*\)`)
+)
+
 func (dc *DockerClient) extractHostsFromLabels(labels map[string]string) []string {
 	var hosts []string
-	hostRegex := regexp.MustCompile(`Host\(\s*\x60([^` + "`" + `]+)\x60\s*\)`)
 	
 	for key, value := range labels {
 		if strings.Contains(key, "traefik.http.routers.") && strings.Contains(key, ".rule") {
@@ -47,18 +102,129 @@ func (dc *DockerClient) extractHostsFromLabels(labels map[string]string) []strin
 	return hosts
 }
 
-func (dc *DockerClient) handleContainerEvent(event events.Message) {
-	if event.Type != events.ContainerEventType {
-		return
+// extractWildcardsFromLabels returns the wildcard names of a container: recognizable
+// Traefik HostRegexp rules, plus *.<host> for every host when dnsherpa.wildcard=true
+// or the names listed in dnsherpa.wildcard
+func (dc *DockerClient) extractWildcardsFromLabels(labels map[string]string, hosts []string) []string {
+	var wildcards []string
+	
+	for key, value := range labels {
+		if strings.Contains(key, "traefik.http.routers.") && strings.Contains(key, ".rule") {
+			matches := hostRegexpRegex.FindAllStringSubmatch(value, -1)
+			for _, match := range matches {
+				if wildcard, ok := wildcardFromHostRegexp(match[1]); ok {
+					wildcards = append(wildcards, wildcard)
+				} else {
+					log.WithField("pattern", match[1]).Debug("HostRegexp rule cannot be mapped to a wildcard record")
+				}
+			}
+		}
+	}
+	
+	if value, found := labels["dnsherpa.wildcard"]; found {
+		if enabled, err := strconv.ParseBool(value); err == nil {
+			if enabled {
+				for _, host := range hosts {
+					wildcards = append(wildcards, wildcardName(host))
+				}
+			}
+		} else {
+			for _, name := range splitList(value) {
+				wildcards = append(wildcards, wildcardName(name))
+			}
+		}
 	}
+	
+	return wildcards
+}
 
-	// Only handle container start events
-	if event.Action != "start" {
-		return
+// extractEntrypointsFromLabels maps every host and wildcard of a container's
+// Traefik routers to the entrypoints of those routers, in router name order
+func (dc *DockerClient) extractEntrypointsFromLabels(labels map[string]string) map[string][]string {
+	var routers []string
+	for key := range labels {
+		if router, found := strings.CutPrefix(key, "traefik.http.routers."); found && strings.HasSuffix(router, ".rule") {
+			routers = append(routers, strings.TrimSuffix(router, ".rule"))
+		}
+	}
+	sort.Strings(routers)
+	
+	entrypoints := make(map[string][]string)
+	for _, router := range routers {
+		routerEntrypoints := splitList(labels["traefik.http.routers."+router+".entrypoints"])
+		if len(routerEntrypoints) == 0 {
+			continue
+		}
+		
+		rule := labels["traefik.http.routers."+router+".rule"]
+		var names []string
+		for _, match := range hostRegex.FindAllStringSubmatch(rule, -1) {
+			names = append(names, match[1])
+		}
+		for _, match := range hostRegexpRegex.FindAllStringSubmatch(rule, -1) {
+			if wildcard, ok := wildcardFromHostRegexp(match[1]); ok {
+				names = append(names, wildcard)
+			}
+		}
+		for _, name := range names {
+			entrypoints[name] = append(entrypoints[name], routerEntrypoints...)
+		}
+	}
+	
+	return entrypoints
+}
+
+// extractSRVFromLabels collects SRV specs from the dnsherpa.srv label (comma-separated)
+// and any dnsherpa.srv.<name> labels
+func (dc *DockerClient) extractSRVFromLabels(labels map[string]string) []string {
+	var specs []string
+	for key, value := range labels {
+		if key == "dnsherpa.srv" || strings.HasPrefix(key, "dnsherpa.srv.") {
+			specs = append(specs, splitList(value)...)
+		}
+	}
+	return specs
+}
+
+// extractTXTFromLabels collects TXT records from dnsherpa.txt.<name> labels. The
+// name is placed below each host (or used as-is when fully qualified); TXT on the
+// host name itself is not offered because it would clash with its CNAME/A record.
+func (dc *DockerClient) extractTXTFromLabels(labels map[string]string) []TXTRecord {
+	texts := make(map[string]string)
+	for key, value := range labels {
+		if name := strings.TrimPrefix(key, "dnsherpa.txt."); name != key && name != "" && name != "@" {
+			texts[name] = value
+		}
+	}
+	return txtRecordsFromMap(texts)
+}
+
+// handleContainerEvent updates the records of a started or stopped container.
+// It reports whether the event brought records up to date: it returns false
+// for events that concern no records, and if the container could not be read
+// or a record failed; names refused by CONFLICT_POLICY are not failures.
+func (dc *DockerClient) handleContainerEvent(event events.Message) bool {
+	if event.Type != events.ContainerEventType {
+		return false
 	}
 
 	containerID := event.ID
 	
+	// Exec actions carry the command after a colon
+	action, _, _ := strings.Cut(string(event.Action), ":")
+	dockerEvents.WithLabelValues(action).Inc()
+	
+	// Withdraw records when a container stops
+	if event.Action == "die" {
+		published := dc.hasRecords(containerID)
+		return dc.removeContainerRecords(containerID) && published
+	}
+	
+	// Otherwise only handle container start events
+	if event.Action != "start" {
+		return false
+	}
+	
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	
@@ -68,12 +234,13 @@ func (dc *DockerClient) handleContainerEvent(event events.Message) {
 			"container_id": containerID,
 			"error":        err,
 		}).Error("Failed to inspect container")
-		return
+		return false
 	}
 	
 	hosts := dc.extractHostsFromLabels(container.Config.Labels)
-	if len(hosts) == 0 {
-		return
+	wildcards := dc.extractWildcardsFromLabels(container.Config.Labels, hosts)
+	if len(hosts) == 0 && len(wildcards) == 0 {
+		return false
 	}
 	
 	// Create DNS records for all hosts
@@ -81,16 +248,214 @@ func (dc *DockerClient) handleContainerEvent(event events.Message) {
 		"container_id":   containerID,
 		"container_name": container.Name,
 		"hosts":          hosts,
+		"wildcards":      wildcards,
 	}).Info("Processing Docker container for DNS records")
 	
-	for _, host := range hosts {
-		if err := dc.etcdClient.CreateDNSRecord(host); err != nil {
+	return !dc.publishContainer(containerID, container.Name, hosts, wildcards, container.Config.Labels).failed
+}
+
+// publishResult is the outcome of publishing the records of a container
+type publishResult struct {
+	failed  bool // a record could not be written
+	refused bool // a name was refused by CONFLICT_POLICY
+}
+
+// add records the error of a record write
+func (r *publishResult) add(err error) {
+	if errors.Is(err, errConflictRefused) {
+		r.refused = true
+	} else {
+		r.failed = true
+	}
+}
+
+// publishContainer creates the records for a container's hosts and wildcard names
+// in each view it belongs to
+func (dc *DockerClient) publishContainer(containerID, name string, hosts, wildcards []string, labels map[string]string) publishResult {
+	dc.mu.Lock()
+	dc.containerNames[containerID] = strings.TrimPrefix(name, "/")
+	dc.mu.Unlock()
+	
+	services, err := parseSRVSpecs(dc.extractSRVFromLabels(labels))
+	if err != nil {
+		log.WithFields(map[string]interface{}{
+			"container_id": containerID,
+			"error":        err,
+		}).Error("Invalid SRV label")
+	}
+	txtRecords := dc.extractTXTFromLabels(labels)
+	entrypoints := dc.extractEntrypointsFromLabels(labels)
+	
+	var ttl int
+	if value, found := labels["dnsherpa.ttl"]; found {
+		ttl, err = strconv.Atoi(value)
+		if err != nil || ttl < 0 {
+			log.WithFields(map[string]interface{}{
+				"container_id": containerID,
+				"ttl":          value,
+			}).Error("Invalid TTL label, using the default TTL")
+			ttl = 0
+		}
+	}
+	
+	records := containerRecords{
+		hosts:       hosts,
+		wildcards:   wildcards,
+		services:    services,
+		txt:         txtRecords,
+		entrypoints: entrypoints,
+		ttl:         ttl,
+	}
+	
+	var result publishResult
+	for _, view := range dc.views {
+		if !containerInView(labels, view.name) {
+			continue
+		}
+		viewResult := dc.publishView(view, containerID, records, containerViewTarget(labels, view.name))
+		result.failed = result.failed || viewResult.failed
+		result.refused = result.refused || viewResult.refused
+	}
+	return result
+}
+
+// publishView creates the records of a container in one view. A non-empty
+// target replaces the target the view would pick for the container's names.
+func (dc *DockerClient) publishView(view *dockerView, containerID string, records containerRecords, target string) publishResult {
+	var keys []string
+	var result publishResult
+	ec := view.etcdClient
+	
+	targetOf := func(name string, entrypoints []string) string {
+		if target != "" {
+			return target
+		}
+		return ec.DockerTarget(name, entrypoints)
+	}
+	
+	for _, host := range records.hosts {
+		written, err := ec.CreateDNSRecord(host, targetOf(host, records.entrypoints[host]), records.ttl)
+		keys = append(keys, written...)
+		if err != nil {
 			log.WithFields(map[string]interface{}{
 				"host":  host,
+				"view":  view.name,
 				"error": err,
 			}).Error("Failed to create DNS record")
+			result.add(err)
+		}
+		
+		written, err = ec.CreateSRVRecords(host, records.services, ec.DockerTTL(host, records.ttl), ec.DockerOwner())
+		keys = append(keys, written...)
+		if err != nil {
+			log.WithFields(map[string]interface{}{
+				"host":  host,
+				"error": err,
+			}).Error("Failed to create SRV record")
+			result.add(err)
+		}
+		
+		written, err = ec.CreateTXTRecords(host, records.txt, ec.DockerTTL(host, records.ttl), ec.DockerOwner())
+		keys = append(keys, written...)
+		if err != nil {
+			log.WithFields(map[string]interface{}{
+				"host":  host,
+				"error": err,
+			}).Error("Failed to create TXT record")
+			result.add(err)
 		}
 	}
+	
+	// Wildcards point at the same target as normal hosts; those added with the
+	// dnsherpa.wildcard label use the entrypoints of their host
+	for _, wildcard := range records.wildcards {
+		wildcardEntrypoints, found := records.entrypoints[wildcard]
+		if !found {
+			wildcardEntrypoints = records.entrypoints[strings.TrimPrefix(wildcard, "*.")]
+		}
+		written, err := ec.CreateDNSRecord(wildcard, targetOf(wildcard, wildcardEntrypoints), records.ttl)
+		keys = append(keys, written...)
+		if err != nil {
+			log.WithFields(map[string]interface{}{
+				"host":  wildcard,
+				"error": err,
+			}).Error("Failed to create wildcard DNS record")
+			result.add(err)
+		}
+	}
+	
+	dc.mu.Lock()
+	view.containerKeys[containerID] = keys
+	dc.mu.Unlock()
+	return result
+}
+
+// hasRecords reports whether records were published for the container
+func (dc *DockerClient) hasRecords(containerID string) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	
+	for _, view := range dc.views {
+		if _, found := view.containerKeys[containerID]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// removeContainerRecords deletes the records of a stopped container that no
+// other running container still publishes, returning false if any failed
+func (dc *DockerClient) removeContainerRecords(containerID string) bool {
+	removed := true
+	for _, view := range dc.views {
+		if !dc.removeViewRecords(view, containerID) {
+			removed = false
+		}
+	}
+	
+	dc.mu.Lock()
+	delete(dc.containerNames, containerID)
+	dc.mu.Unlock()
+	return removed
+}
+
+// removeViewRecords deletes the records of a stopped container from one view
+func (dc *DockerClient) removeViewRecords(view *dockerView, containerID string) bool {
+	dc.mu.Lock()
+	keys, found := view.containerKeys[containerID]
+	delete(view.containerKeys, containerID)
+	inUse := make(map[string]bool)
+	for _, otherKeys := range view.containerKeys {
+		for _, key := range otherKeys {
+			inUse[key] = true
+		}
+	}
+	dc.mu.Unlock()
+	if !found {
+		return true
+	}
+	
+	var unused []string
+	for _, key := range keys {
+		if !inUse[key] {
+			unused = append(unused, key)
+		}
+	}
+	
+	log.WithFields(map[string]interface{}{
+		"container_id": containerID,
+		"view":         view.name,
+		"record_count": len(unused),
+	}).Info("Removing DNS records of stopped container")
+	
+	if err := view.etcdClient.DeleteRecords(view.etcdClient.DockerOwner(), unused); err != nil {
+		log.WithFields(map[string]interface{}{
+			"container_id": containerID,
+			"error":        err,
+		}).Error("Failed to remove DNS records")
+		return false
+	}
+	return true
 }
 
 func (dc *DockerClient) SyncExistingContainers() error {
@@ -104,46 +469,86 @@ func (dc *DockerClient) SyncExistingContainers() error {
 	
 	log.WithField("container_count", len(containers)).Info("Syncing existing containers")
 	
+	complete := true
+	refused := false
 	for _, container := range containers {
 		hosts := dc.extractHostsFromLabels(container.Labels)
-		if len(hosts) > 0 {
+		wildcards := dc.extractWildcardsFromLabels(container.Labels, hosts)
+		if len(hosts) > 0 || len(wildcards) > 0 {
 			log.WithFields(map[string]interface{}{
 				"container_id":   container.ID,
 				"container_name": strings.Join(container.Names, ","),
 				"hosts":          hosts,
+				"wildcards":      wildcards,
 			}).Debug("Found hosts in container labels")
 			
-			for _, host := range hosts {
-				if err := dc.etcdClient.CreateDNSRecord(host); err != nil {
-					log.WithFields(map[string]interface{}{
-						"host":  host,
-						"error": err,
-					}).Error("Failed to create DNS record during sync")
-				}
+			result := dc.publishContainer(container.ID, firstName(container.Names), hosts, wildcards, container.Labels)
+			if result.failed {
+				complete = false
+			}
+			if result.refused {
+				refused = true
 			}
 		}
 	}
 	
+	// Remove records of containers that stopped while we were not watching
+	if !complete {
+		return errIncompleteSync
+	}
+	var errs []error
+	for _, view := range dc.views {
+		keep := make(map[string]bool)
+		dc.mu.Lock()
+		for _, keys := range view.containerKeys {
+			for _, key := range keys {
+				keep[key] = true
+			}
+		}
+		dc.mu.Unlock()
+		if err := view.etcdClient.RemoveStaleRecords(view.etcdClient.DockerOwner(), keep); err != nil {
+			errs = append(errs, fmt.Errorf("view %s: %w", view.name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if refused {
+		return errSyncConflicts
+	}
 	return nil
 }
 
 func (dc *DockerClient) StartEventMonitoring(ctx context.Context) error {
 	log.Info("Starting Docker event monitoring...")
 	
-	if err := dc.SyncExistingContainers(); err != nil {
+	err := dc.SyncExistingContainers()
+	dc.syncs.record(err)
+	if err != nil {
 		log.WithError(err).Warn("Failed to sync existing containers")
 	}
 	
 	eventChan, errChan := dc.client.Events(ctx, events.ListOptions{})
+	dc.connected.Store(true)
+	defer dc.connected.Store(false)
 	
 	log.Info("Listening for Docker events...")
 	
 	for {
 		select {
 		case event := <-eventChan:
-			dc.handleContainerEvent(event)
+			if dc.handleContainerEvent(event) {
+				dc.syncs.recordEvent()
+			}
+		case <-dc.resync:
+			err := dc.SyncExistingContainers()
+			dc.syncs.record(err)
+			if err != nil {
+				log.WithError(err).Warn("Failed to resync containers")
+			}
 		case err := <-errChan:
 			if err != nil {
+				dc.syncs.record(fmt.Errorf("event stream: %w", err))
 				log.WithError(err).Error("Docker events stream error")
 				return err
 			}
@@ -153,6 +558,45 @@ func (dc *DockerClient) StartEventMonitoring(ctx context.Context) error {
 	}
 }
 
+// Resync republishes all running containers, for example after the
+// configuration was reloaded. The sync runs on the event loop, so the event
+// stream is not interrupted.
+func (dc *DockerClient) Resync() {
+	select {
+	case dc.resync <- struct{}{}:
+	default:
+	}
+}
+
+// Origins returns the containers each published key belongs to
+func (dc *DockerClient) Origins() map[string][]RecordOrigin {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	
+	origins := make(map[string][]RecordOrigin)
+	for _, view := range dc.views {
+		for containerID, keys := range view.containerKeys {
+			origin := RecordOrigin{
+				Source:        "docker",
+				ContainerID:   containerID,
+				ContainerName: dc.containerNames[containerID],
+			}
+			for _, key := range keys {
+				origins[key] = append(origins[key], origin)
+			}
+		}
+	}
+	return origins
+}
+
+// firstName returns the first of the names Docker lists for a container
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
 func (dc *DockerClient) Close() {
 	if dc.client != nil {
 		dc.client.Close()
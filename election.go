@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// campaignRetryDelay is how long a candidate waits after a failed campaign
+const campaignRetryDelay = 5 * time.Second
+
+// electionStatusTimeout bounds the lookup of the current leader
+const electionStatusTimeout = 2 * time.Second
+
+// LeaderElector runs work only while this instance holds the etcd leadership
+type LeaderElector struct {
+	prefix string
+	id     string
+	ttl    int
+
+	mu       sync.Mutex
+	client   *clientv3.Client
+	username string
+	password string
+	leader   bool
+	session  *concurrency.Session
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewLeaderElector connects a candidate identified by AGENT_ID to the election
+// under LEADER_ELECTION_PREFIX
+func NewLeaderElector(config Config) (*LeaderElector, error) {
+	client, err := newElectionClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LeaderElector{
+		client:   client,
+		username: config.EtcdUsername,
+		password: config.EtcdPassword,
+		prefix:   config.LeaderElectionPrefix,
+		id:       config.AgentID,
+		ttl:      config.LeaderElectionTTL,
+	}, nil
+}
+
+func newElectionClient(config Config) (*clientv3.Client, error) {
+	clientConfig, err := etcdClientConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd election client: %w", err)
+	}
+	return client, nil
+}
+
+// Reconfigure reconnects with the etcd credentials of config if they changed.
+// The session of a leader ends with the old connection, so it campaigns again.
+func (le *LeaderElector) Reconfigure(config Config) error {
+	le.mu.Lock()
+	unchanged := le.username == config.EtcdUsername && le.password == config.EtcdPassword
+	le.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	client, err := newElectionClient(config)
+	if err != nil {
+		return err
+	}
+
+	le.mu.Lock()
+	previous := le.client
+	le.client, le.username, le.password = client, config.EtcdUsername, config.EtcdPassword
+	le.mu.Unlock()
+
+	previous.Close()
+	log.Info("Reconnected the leader election with new etcd credentials")
+	return nil
+}
+
+// etcd returns the client of the current etcd credentials
+func (le *LeaderElector) etcd() *clientv3.Client {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	return le.client
+}
+
+// Run campaigns for leadership and calls lead while this instance is the
+// leader. The context passed to lead is cancelled when leadership is lost;
+// afterwards onLoss is called and the instance campaigns again. Run returns
+// when ctx is cancelled or the elector is closed.
+func (le *LeaderElector) Run(ctx context.Context, lead func(context.Context) error, onLoss func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	le.mu.Lock()
+	le.cancel = cancel
+	le.done = done
+	le.mu.Unlock()
+	defer close(done)
+	defer cancel()
+
+	for {
+		if leader := le.currentLeader(ctx); leader != "" {
+			log.WithFields(map[string]interface{}{
+				"candidate": le.id,
+				"leader":    leader,
+			}).Info("Standing by as follower")
+		}
+
+		session, election, err := le.campaign(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.WithError(err).Error("Leader election campaign failed")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(campaignRetryDelay):
+			}
+			continue
+		}
+
+		err = le.lead(ctx, session, election, lead)
+		le.setLeader(false)
+		le.closeSession()
+		onLoss()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.WithError(err).Warn("Lost leadership, campaigning again")
+	}
+}
+
+// campaign opens a session and blocks until this instance is elected on it
+func (le *LeaderElector) campaign(ctx context.Context) (*concurrency.Session, *concurrency.Election, error) {
+	session, err := concurrency.NewSession(le.etcd(), concurrency.WithTTL(le.ttl))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get election session: %w", err)
+	}
+	election := concurrency.NewElection(session, le.prefix)
+
+	le.mu.Lock()
+	le.session = session
+	le.mu.Unlock()
+
+	if err := election.Campaign(ctx, le.id); err != nil {
+		le.closeSession()
+		return nil, nil, err
+	}
+	return session, election, nil
+}
+
+// lead runs lead until it returns or the session backing the leadership ends
+func (le *LeaderElector) lead(ctx context.Context, session *concurrency.Session, election *concurrency.Election, lead func(context.Context) error) error {
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan struct{})
+	go func() {
+		select {
+		case <-session.Done():
+			close(lost)
+			cancel()
+		case <-leadCtx.Done():
+		}
+	}()
+
+	le.setLeader(true)
+	log.WithField("leader", le.id).Info("Became leader")
+
+	err := lead(leadCtx)
+	cancel()
+	le.resign(election)
+
+	select {
+	case <-lost:
+		return errors.New("election session expired")
+	default:
+	}
+	if err == nil {
+		err = errors.New("leader work stopped")
+	}
+	return err
+}
+
+// resign gives up leadership so a follower can take over immediately
+func (le *LeaderElector) resign(election *concurrency.Election) {
+	ctx, cancel := context.WithTimeout(le.etcd().Ctx(), electionStatusTimeout)
+	defer cancel()
+
+	if err := election.Resign(ctx); err != nil {
+		log.WithError(err).Warn("Failed to resign leadership")
+	}
+}
+
+// closeSession revokes the current session, dropping the candidacy held with it
+func (le *LeaderElector) closeSession() {
+	le.mu.Lock()
+	session := le.session
+	le.session = nil
+	le.mu.Unlock()
+
+	if session != nil {
+		session.Close()
+	}
+}
+
+// currentLeader returns the id of the current leader, empty if there is none
+func (le *LeaderElector) currentLeader(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, electionStatusTimeout)
+	defer cancel()
+
+	resp, err := le.etcd().Get(ctx, le.prefix+"/", clientv3.WithFirstCreate()...)
+	if err != nil || len(resp.Kvs) == 0 {
+		return ""
+	}
+	return string(resp.Kvs[0].Value)
+}
+
+func (le *LeaderElector) setLeader(leader bool) {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	le.leader = leader
+}
+
+// Status reports whether this instance is the leader and who currently leads
+func (le *LeaderElector) Status() (bool, string) {
+	le.mu.Lock()
+	isLeader := le.leader
+	le.mu.Unlock()
+
+	return isLeader, le.currentLeader(context.Background())
+}
+
+// Close stops Run and waits for it to return, then revokes the election
+// session and closes the client
+func (le *LeaderElector) Close() {
+	le.mu.Lock()
+	cancel, done := le.cancel, le.done
+	le.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	le.closeSession()
+	le.etcd().Close()
+}
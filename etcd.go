@@ -9,48 +9,206 @@ import (
 	"io/ioutil"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
-	"go.etcd.io/etcd/clientv3"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// DNSRecord is a SkyDNS message as served by the CoreDNS etcd plugin
 type DNSRecord struct {
-	Host string `json:"host,omitempty"`
-	TTL  int    `json:"ttl"`
+	Host        string `json:"host,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+	Weight      int    `json:"weight,omitempty"`
+	Text        string `json:"text,omitempty"`
+	Mail        bool   `json:"mail,omitempty"`
+	TTL         int    `json:"ttl"`
+	TargetStrip int    `json:"targetstrip,omitempty"`
+	Group       string `json:"group,omitempty"`
+	
+	// Owner identifies the DNSherpa source that published the record.
+	// CoreDNS ignores the field.
+	Owner string `json:"owner,omitempty"`
+}
+
+
+// etcdSettings is the configuration of an EtcdClient together with the values
+// parsed from it. It is replaced as a whole when the configuration is reloaded.
+type etcdSettings struct {
+	Config
+	reverseZones      []*net.IPNet
+	domainTTLs        []domainTTL
+	domainTargets     []domainTarget
+	entrypointTargets map[string]string
 }
 
 type EtcdClient struct {
-	client *clientv3.Client
-	config Config
+	clientMu sync.RWMutex
+	client   *clientv3.Client
+	
+	settingsMu sync.RWMutex
+	current    *etcdSettings
+	
+	warmingMu sync.Mutex
+	warming   map[string]*recordState
+	
+	// managed holds the value last written to every key, to spot tampering
+	managedMu sync.Mutex
+	managed   map[string]string
+	
+	statsMu   sync.Mutex
+	conflicts map[string]int
+	tampering map[string]int
+	
+	// plan collects the changes not written in a dry run, nil otherwise
+	plan *Plan
 }
 
 func NewEtcdClient(config Config) (*EtcdClient, error) {
-	// Build etcd client config
+	etcdConfig, err := etcdClientConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := newEtcdSettings(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clientv3.New(etcdConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %w", err)
+	}
+
+	ec := &EtcdClient{
+		client:    client,
+		current:   settings,
+		warming:   make(map[string]*recordState),
+		managed:   make(map[string]string),
+		conflicts: make(map[string]int),
+		tampering: make(map[string]int),
+	}
+	if config.DryRun {
+		ec.plan = NewPlan()
+	}
+	return ec, nil
+}
+
+// etcdClientConfig builds the etcd client config with the TLS and
+// authentication settings of config
+func etcdClientConfig(config Config) (clientv3.Config, error) {
 	etcdConfig := clientv3.Config{
 		Endpoints:   config.EtcdEndpoints,
 		DialTimeout: 5 * time.Second,
+		Username:    config.EtcdUsername,
+		Password:    config.EtcdPassword,
 	}
 
 	// Add TLS configuration if enabled
 	if config.EtcdTLS {
 		tlsConfig, err := buildTLSConfig(config)
 		if err != nil {
-			return nil, fmt.Errorf("failed to build TLS config: %w", err)
+			return clientv3.Config{}, fmt.Errorf("failed to build TLS config: %w", err)
 		}
 		etcdConfig.TLS = tlsConfig
 	}
+	return etcdConfig, nil
+}
 
-	client, err := clientv3.New(etcdConfig)
+func newEtcdSettings(config Config) (*etcdSettings, error) {
+	reverseZones, err := parseReverseZones(config.ReverseZones)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create etcd client: %w", err)
+		return nil, fmt.Errorf("invalid REVERSE_ZONES: %w", err)
+	}
+
+	domainTTLs, err := parseDomainTTLs(config.DomainTTLs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOMAIN_TTLS: %w", err)
+	}
+
+	domainTargets, err := parseDomainTargets(config.DomainTargets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOMAIN_TARGETS: %w", err)
 	}
 
-	return &EtcdClient{
-		client: client,
-		config: config,
+	entrypointTargets, err := parseEntrypointTargets(config.EntrypointTargets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENTRYPOINT_TARGETS: %w", err)
+	}
+
+	return &etcdSettings{
+		Config:            config,
+		reverseZones:      reverseZones,
+		domainTTLs:        domainTTLs,
+		domainTargets:     domainTargets,
+		entrypointTargets: entrypointTargets,
 	}, nil
 }
 
+// settings returns the current configuration
+func (ec *EtcdClient) settings() *etcdSettings {
+	ec.settingsMu.RLock()
+	defer ec.settingsMu.RUnlock()
+
+	return ec.current
+}
+
+// Reconfigure applies a reloaded configuration to records written from now on.
+// Changed credentials reconnect the client. The endpoints, TLS files, prefix
+// and agent ID cannot change while the client runs, so the caller keeps those
+// settings of the running configuration.
+func (ec *EtcdClient) Reconfigure(config Config) error {
+	settings, err := newEtcdSettings(config)
+	if err != nil {
+		return err
+	}
+
+	running := ec.settings()
+	if config.EtcdUsername != running.EtcdUsername || config.EtcdPassword != running.EtcdPassword {
+		if err := ec.reconnect(config); err != nil {
+			return err
+		}
+	}
+
+	ec.settingsMu.Lock()
+	defer ec.settingsMu.Unlock()
+
+	ec.current = settings
+	return nil
+}
+
+// reconnect replaces the etcd client with one using the credentials of config.
+// Requests still running on the old client fail and are retried by their
+// source; the watch reconnects on its own.
+func (ec *EtcdClient) reconnect(config Config) error {
+	etcdConfig, err := etcdClientConfig(config)
+	if err != nil {
+		return err
+	}
+	client, err := clientv3.New(etcdConfig)
+	if err != nil {
+		return fmt.Errorf("failed to reconnect to etcd: %w", err)
+	}
+
+	ec.clientMu.Lock()
+	previous := ec.client
+	ec.client = client
+	ec.clientMu.Unlock()
+
+	previous.Close()
+	log.WithField("prefix", config.EtcdPrefix).Info("Reconnected to etcd with new credentials")
+	return nil
+}
+
+// etcd returns the client of the current etcd credentials
+func (ec *EtcdClient) etcd() *clientv3.Client {
+	ec.clientMu.RLock()
+	defer ec.clientMu.RUnlock()
+
+	return ec.client
+}
+
 func buildTLSConfig(config Config) (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
 
@@ -80,128 +238,322 @@ func buildTLSConfig(config Config) (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
-func (ec *EtcdClient) CreateDNSRecord(hostname string) error {
-	parts := strings.Split(hostname, ".")
-	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
-		parts[i], parts[j] = parts[j], parts[i]
+// CreateDNSRecord points a Docker host at target, usually picked by
+// DockerTarget, and returns the keys written. A ttl of 0 uses the Docker
+// default TTL.
+//
+// The record is stored under a sub-key of this agent, so when the same host
+// is served by several Docker hosts CoreDNS returns all of their targets and
+// each agent only ever replaces or withdraws its own entry.
+func (ec *EtcdClient) CreateDNSRecord(hostname, target string, ttl int) ([]string, error) {
+	key := ec.hostKey(hostname) + "/" + ec.agentSubKey()
+	return ec.createTargetRecordAt(key, hostname, target, ec.DockerTTL(hostname, ttl), ec.DockerOwner())
+}
+
+// DockerTarget picks the target of a Docker host: the first of its Traefik
+// entrypoints listed in ENTRYPOINT_TARGETS, then the most specific
+// DOMAIN_TARGETS entry, then DNS_TARGET
+func (ec *EtcdClient) DockerTarget(hostname string, entrypoints []string) string {
+	settings := ec.settings()
+
+	for _, entrypoint := range entrypoints {
+		if target, found := settings.entrypointTargets[entrypoint]; found {
+			return target
+		}
+	}
+
+	for _, override := range settings.domainTargets {
+		if hostname == override.domain || strings.HasSuffix(hostname, "."+override.domain) {
+			return override.target
+		}
+	}
+
+	return settings.DNSTarget
+}
+
+// agentSubKey is the sub-key this agent publishes Docker records under
+func (ec *EtcdClient) agentSubKey() string {
+	return recordSubKey(strings.ReplaceAll(ec.settings().AgentID, "/", "_"))
+}
+
+// ownerSubKey is appended to the keys of records that several sources may
+// publish at the same name, so each owner only ever writes its own key
+func ownerSubKey(owner string) string {
+	return recordSubKey(strings.ReplaceAll(owner, "/", "_"))
+}
+
+// DockerOwner is the record owner used by this agent's Docker source
+func (ec *EtcdClient) DockerOwner() string {
+	return "docker/" + ec.settings().AgentID
+}
+
+// DockerTTL resolves the TTL of a Docker record from its label value
+func (ec *EtcdClient) DockerTTL(hostname string, ttl int) int {
+	return ec.ResolveTTL(hostname, ec.settings().DockerRecordTTL, ttl)
+}
+
+// CreateTargetRecord points hostname at target, creating an A/AAAA record for
+// an IP target and a CNAME record otherwise. A ttl of 0 uses the default TTL.
+// It returns the keys written, including any PTR record.
+func (ec *EtcdClient) CreateTargetRecord(hostname, target string, ttl int, owner string) ([]string, error) {
+	return ec.createTargetRecordAt(ec.hostKey(hostname), hostname, target, ttl, owner)
+}
+
+// createTargetRecordAt writes the record of CreateTargetRecord to key
+func (ec *EtcdClient) createTargetRecordAt(key, hostname, target string, ttl int, owner string) ([]string, error) {
+	record := DNSRecord{
+		Host:  target,
+		TTL:   ec.recordTTL(ttl),
+		Owner: owner,
 	}
-	key := fmt.Sprintf("%s/%s", ec.config.EtcdPrefix, strings.Join(parts, "/"))
-	
-	var record DNSRecord
-	target := ec.config.DNSTarget
 	
 	// Check if target is an IP address
-	if ip := net.ParseIP(target); ip != nil {
-		// Create A or AAAA record for IP
-		record = DNSRecord{
-			Host: target,
-			TTL:  ec.config.RecordTTL,
-		}
+	recordType := "CNAME"
+	ip := net.ParseIP(target)
+	if ip != nil {
 		if ip.To4() != nil {
-			log.WithFields(map[string]interface{}{
-				"hostname": hostname,
-				"target":   target,
-				"type":     "A",
-			}).Info("Creating DNS record")
+			recordType = "A"
 		} else {
-			log.WithFields(map[string]interface{}{
-				"hostname": hostname,
-				"target":   target,
-				"type":     "AAAA",
-			}).Info("Creating DNS record")
-		}
-	} else {
-		// Create CNAME record for hostname
-		record = DNSRecord{
-			Host: target,
-			TTL:  ec.config.RecordTTL,
+			recordType = "AAAA"
 		}
-		log.WithFields(map[string]interface{}{
-			"hostname": hostname,
-			"target":   target,
-			"type":     "CNAME",
-		}).Info("Creating DNS record")
-	}
-	
-	recordJSON, err := json.Marshal(record)
-	if err != nil {
-		return fmt.Errorf("failed to marshal DNS record: %w", err)
 	}
 	
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	
-	_, err = ec.client.Put(ctx, key, string(recordJSON))
+	written, err := ec.writeName(ctx, hostname, []string{key}, []DNSRecord{record}, owner)
 	if err != nil {
-		return fmt.Errorf("failed to create DNS record for %s: %w", hostname, err)
+		return nil, fmt.Errorf("failed to create DNS record for %s: %w", hostname, err)
+	}
+	if !written {
+		return nil, fmt.Errorf("DNS record for %s: %w", hostname, errConflictRefused)
 	}
 	
-	return nil
+	log.WithFields(map[string]interface{}{
+		"hostname": hostname,
+		"target":   target,
+		"type":     recordType,
+		"ttl":      record.TTL,
+		"owner":    owner,
+	}).Info("Created DNS record")
+	
+	keys := []string{key}
+	if ip != nil {
+		ptrKeys, err := ec.createPTRRecords(ctx, hostname, []string{target}, ttl, owner)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, ptrKeys...)
+	}
+	
+	return keys, nil
 }
 
-func (ec *EtcdClient) CreateDNSRecords(hostname string, ips []string) error {
-	parts := strings.Split(hostname, ".")
-	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
-		parts[i], parts[j] = parts[j], parts[i]
-	}
-	basePath := fmt.Sprintf("%s/%s", ec.config.EtcdPrefix, strings.Join(parts, "/"))
+// CreateDNSRecords creates A/AAAA records for every IP under numbered sub-keys
+// of hostname. A ttl of 0 uses the default TTL. It returns the keys written,
+// including any PTR records.
+func (ec *EtcdClient) CreateDNSRecords(hostname string, ips []string, ttl int, owner string) ([]string, error) {
+	basePath := ec.hostKey(hostname)
 	
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	
 	var ipv4Count, ipv6Count int
-	var createdRecords []string
+	var recordKeys, recordTypes, recordIPs []string
 	
 	for _, ip := range ips {
-		var key string
-		var recordType string
-		
 		if netIP := net.ParseIP(ip); netIP != nil {
 			if netIP.To4() != nil {
 				// IPv4 - A record
 				ipv4Count++
-				key = fmt.Sprintf("%s/a%d", basePath, ipv4Count)
-				recordType = "A"
+				recordKeys = append(recordKeys, fmt.Sprintf("%s/a%d", basePath, ipv4Count))
+				recordTypes = append(recordTypes, "A")
 			} else {
 				// IPv6 - AAAA record  
 				ipv6Count++
-				key = fmt.Sprintf("%s/aaaa%d", basePath, ipv6Count)
-				recordType = "AAAA"
-			}
-			
-			record := DNSRecord{Host: ip, TTL: ec.config.RecordTTL}
-			recordJSON, err := json.Marshal(record)
-			if err != nil {
-				return fmt.Errorf("failed to marshal DNS record: %w", err)
+				recordKeys = append(recordKeys, fmt.Sprintf("%s/aaaa%d", basePath, ipv6Count))
+				recordTypes = append(recordTypes, "AAAA")
 			}
-			
-			_, err = ec.client.Put(ctx, key, string(recordJSON))
-			if err != nil {
-				return fmt.Errorf("failed to create %s record for %s: %w", recordType, hostname, err)
-			}
-			
-			createdRecords = append(createdRecords, fmt.Sprintf("%s->%s", recordType, ip))
-			log.WithFields(map[string]interface{}{
-				"hostname": hostname,
-				"ip":       ip,
-				"type":     recordType,
-			}).Info("Created DNS record")
+			recordIPs = append(recordIPs, ip)
 		}
 	}
+	if len(recordKeys) == 0 {
+		return nil, nil
+	}
+	
+	records := make([]DNSRecord, len(recordKeys))
+	for i := range recordKeys {
+		records[i] = DNSRecord{Host: recordIPs[i], TTL: ec.recordTTL(ttl), Owner: owner}
+	}
+	
+	// All addresses of the name are replaced at once, including stale indices
+	written, err := ec.writeName(ctx, hostname, recordKeys, records, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS records for %s: %w", hostname, err)
+	}
+	if !written {
+		return nil, fmt.Errorf("DNS records for %s: %w", hostname, errConflictRefused)
+	}
+	
+	var createdRecords []string
+	keys := append([]string(nil), recordKeys...)
+	for i := range recordKeys {
+		createdRecords = append(createdRecords, fmt.Sprintf("%s->%s", recordTypes[i], recordIPs[i]))
+		log.WithFields(map[string]interface{}{
+			"hostname": hostname,
+			"ip":       recordIPs[i],
+			"type":     recordTypes[i],
+			"owner":    owner,
+		}).Info("Created DNS record")
+	}
+	
+	ptrKeys, err := ec.createPTRRecords(ctx, hostname, ips, ttl, owner)
+	if err != nil {
+		return keys, err
+	}
+	keys = append(keys, ptrKeys...)
 	
 	if len(createdRecords) > 0 {
 		log.WithFields(map[string]interface{}{
 			"hostname":     hostname,
 			"record_count": len(createdRecords),
 			"records":      strings.Join(createdRecords, ", "),
+			"ptr_count":    len(ptrKeys),
 		}).Info("DNS records created successfully")
 	}
 	
+	return keys, nil
+}
+
+// DeleteRecords removes those of the given keys that still hold a record of
+// owner. A key another owner has written since is left alone.
+func (ec *EtcdClient) DeleteRecords(owner string, keys []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	
+	for _, key := range keys {
+		// Forget the key first so the watch does not restore it
+		ec.forgetRecords([]string{key})
+		
+		done := etcdTimer("get")
+		resp, err := ec.etcd().Get(ctx, key)
+		done()
+		if err != nil {
+			return fmt.Errorf("failed to read DNS record %s: %w", key, err)
+		}
+		if len(resp.Kvs) == 0 {
+			continue
+		}
+		var record DNSRecord
+		if json.Unmarshal(resp.Kvs[0].Value, &record) != nil || record.Owner != owner {
+			log.WithFields(map[string]interface{}{
+				"key":   key,
+				"owner": record.Owner,
+			}).Debug("Not deleting DNS record of another owner")
+			continue
+		}
+		
+		if ec.plan != nil {
+			if err := ec.planDelete(ctx, key); err != nil {
+				return err
+			}
+			continue
+		}
+		done = etcdTimer("txn")
+		txn, err := ec.etcd().Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(clientv3.OpDelete(key)).
+			Commit()
+		done()
+		if err != nil {
+			recordFailures.WithLabelValues(ec.settings().EtcdPrefix, "delete").Inc()
+			return fmt.Errorf("failed to delete DNS record %s: %w", key, err)
+		}
+		if !txn.Succeeded {
+			log.WithField("key", key).Debug("DNS record changed concurrently, not deleting it")
+			continue
+		}
+		recordDeletes.WithLabelValues(ec.settings().EtcdPrefix).Inc()
+		log.WithField("key", key).Info("Deleted DNS record")
+	}
+	
 	return nil
 }
 
+// RemoveStaleRecords deletes every record owned by owner whose key is not in keep.
+// Records without an owner (manual or created by older versions) are never touched.
+func (ec *EtcdClient) RemoveStaleRecords(owner string, keep map[string]bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	
+	done := etcdTimer("get")
+	resp, err := ec.etcd().Get(ctx, ec.settings().EtcdPrefix+"/", clientv3.WithPrefix())
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records: %w", err)
+	}
+	
+	var stale []string
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if keep[key] {
+			continue
+		}
+		
+		var record DNSRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			continue // Not a record we understand, leave it alone
+		}
+		if record.Owner == owner {
+			stale = append(stale, key)
+		}
+	}
+	
+	if len(stale) == 0 {
+		return nil
+	}
+	
+	log.WithFields(map[string]interface{}{
+		"owner": owner,
+		"count": len(stale),
+	}).Info("Removing stale DNS records")
+	
+	return ec.DeleteRecords(owner, stale)
+}
+
+// Ping reads the prefix key to check that etcd answers
+func (ec *EtcdClient) Ping(ctx context.Context) error {
+	done := etcdTimer("get")
+	_, err := ec.etcd().Get(ctx, ec.settings().EtcdPrefix)
+	done()
+	return err
+}
+
+// hostKey converts hostname into its SkyDNS etcd key (reversed labels under the prefix)
+func (ec *EtcdClient) hostKey(hostname string) string {
+	parts := strings.Split(hostname, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return fmt.Sprintf("%s/%s", ec.settings().EtcdPrefix, strings.Join(parts, "/"))
+}
+
+// recordSubKey returns the sub-key used when several hostnames share one record
+// name (PTR and SRV records)
+func recordSubKey(hostname string) string {
+	return strings.ReplaceAll(hostname, ".", "_")
+}
+
+// recordTTL returns ttl if set, otherwise the configured default TTL
+func (ec *EtcdClient) recordTTL(ttl int) int {
+	if ttl > 0 {
+		return ttl
+	}
+	return ec.settings().RecordTTL
+}
+
 func (ec *EtcdClient) Close() {
-	if ec.client != nil {
-		ec.client.Close()
+	if client := ec.etcd(); client != nil {
+		client.Close()
 	}
 }
\ No newline at end of file
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultHTTPListen only accepts local connections, which is enough for the
+// healthcheck command run inside the container
+const defaultHTTPListen = "127.0.0.1:9133"
+
+// etcdPingTimeout bounds the etcd reachability check
+const etcdPingTimeout = 2 * time.Second
+
+// HealthCheck is the result of one health check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport is the response of /healthz and /readyz
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Liveness checks the background work that a restart would recover: the
+// Docker event stream and the Proxmox monitors. A cluster is unhealthy when
+// its monitor stopped or has not reached its API for HEALTH_SYNC_INTERVALS
+// intervals; incomplete syncs count as successful if they read at least one node.
+func (da *DNSAutomator) Liveness() []HealthCheck {
+	da.mu.Lock()
+	config := da.config
+	clients := da.proxmoxClients
+	monitoring := da.proxmoxCtx != nil
+	runs := make(map[*ProxmoxClient]*proxmoxRun, len(da.proxmoxRuns))
+	for pc, run := range da.proxmoxRuns {
+		runs[pc] = run
+	}
+	da.mu.Unlock()
+
+	var checks []HealthCheck
+	if config.AgentMode == "docker" || config.AgentMode == "hybrid" {
+		check := HealthCheck{Name: "docker", OK: da.dockerClient.connected.Load()}
+		if !check.OK {
+			check.Message = "Docker event stream not connected"
+		}
+		checks = append(checks, check)
+	}
+
+	for _, pc := range clients {
+		check := HealthCheck{Name: pc.owner, OK: true}
+		run, running := runs[pc]
+		switch {
+		case !monitoring && da.elector != nil:
+			check.Message = "standby"
+		case !monitoring || !running:
+			check.OK = false
+			check.Message = "monitor stopped"
+		default:
+			since := run.started
+			if last := pc.syncs.Status().LastSync; last != nil && last.After(since) {
+				since = *last
+			}
+			limit := time.Duration(config.HealthSyncIntervals) * pc.cluster.PollInterval
+			if elapsed := time.Since(since); elapsed > limit {
+				check.OK = false
+				check.Message = fmt.Sprintf("no successful sync for %s", elapsed.Round(time.Second))
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Readiness adds to Liveness that etcd answers for every view and that every
+// running source has completed a sync, even an incomplete one, or handled an event
+func (da *DNSAutomator) Readiness(ctx context.Context) []HealthCheck {
+	checks := da.Liveness()
+
+	for _, view := range da.allViews() {
+		pingCtx, cancel := context.WithTimeout(ctx, etcdPingTimeout)
+		err := view.EtcdClient.Ping(pingCtx)
+		cancel()
+
+		check := HealthCheck{Name: "etcd/" + view.Name, OK: err == nil}
+		if err != nil {
+			check.Message = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	// Docker records are also kept current by events, so a handled event
+	// makes up for a failed startup sync
+	synced := make(map[string]bool)
+	for _, status := range da.sourceStatuses() {
+		synced[status.Source] = status.LastSync != nil || status.LastEvent != nil
+	}
+	for i, check := range checks {
+		if !check.OK || check.Message == "standby" {
+			continue
+		}
+		if done, found := synced[check.Name]; found && !done {
+			checks[i].OK = false
+			checks[i].Message = "waiting for the first successful sync"
+		}
+	}
+	return checks
+}
+
+// healthReport summarizes checks and returns the HTTP status to answer with
+func healthReport(checks []HealthCheck) (HealthReport, int) {
+	report := HealthReport{Status: "ok", Checks: checks}
+	if report.Checks == nil {
+		report.Checks = []HealthCheck{}
+	}
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = "fail"
+			return report, http.StatusServiceUnavailable
+		}
+	}
+	return report, http.StatusOK
+}
+
+// runHealthcheck queries the health endpoint of the local DNSherpa instance
+// at HTTP_LISTEN and returns the exit code, for use as a Docker HEALTHCHECK
+func runHealthcheck(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	ready := flags.Bool("ready", false, "check /readyz instead of /healthz")
+	timeout := flags.Duration("timeout", 5*time.Second, "request timeout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	address := getEnv("HTTP_LISTEN", defaultHTTPListen)
+	if address == "off" {
+		fmt.Fprintln(os.Stderr, "healthcheck: the HTTP server is disabled with HTTP_LISTEN=off")
+		return 1
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: invalid HTTP_LISTEN %q: %v\n", address, err)
+		return 1
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	path := "/healthz"
+	if *ready {
+		path = "/readyz"
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
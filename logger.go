@@ -13,7 +13,11 @@ var log *logrus.Logger
 // InitializeLogger sets up the global logger with configuration from environment variables
 func InitializeLogger() {
 	log = logrus.New()
-	
+	configureLogger()
+}
+
+// configureLogger applies LOG_LEVEL and LOG_FORMAT to the global logger
+func configureLogger() {
 	// Configure log level
 	levelStr := strings.ToLower(getEnv("LOG_LEVEL", "info"))
 	level, err := logrus.ParseLevel(levelStr)
@@ -101,21 +105,55 @@ func LogConfigurationSummary(config Config) {
 		"dns_target":        config.DNSTarget,
 		"domain":            config.Domain,
 		"record_ttl":        config.RecordTTL,
+		"docker_record_ttl": config.DockerRecordTTL,
+		"domain_ttls":       config.DomainTTLs,
+		"warming_ttl":       config.WarmingTTL,
+		"warming_period":    config.WarmingPeriod,
+		"ptr_records":       config.PTRRecords,
+		"reverse_zones":     config.ReverseZones,
+		"agent_id":          config.AgentID,
+		"conflict_policy":   config.ConflictPolicy,
+		"watch_policy":      config.WatchPolicy,
+		"http_listen":       config.HTTPListen,
+		"dry_run":           config.DryRun,
 	}).Info("Configuration loaded")
 	
+	for _, view := range config.Views {
+		log.WithFields(logrus.Fields{
+			"view":   view.Name,
+			"prefix": view.Prefix,
+			"target": view.Target,
+		}).Info("Split-horizon view configured")
+	}
+	
 	// Log Proxmox-specific config if relevant
 	if config.AgentMode == "proxmox" || config.AgentMode == "hybrid" {
-		if config.ProxmoxAPIURL != "" {
+		if len(config.ProxmoxClusters) == 0 {
+			log.Warn("Proxmox mode enabled but no API URL configured")
+		}
+		for _, cluster := range config.ProxmoxClusters {
 			log.WithFields(logrus.Fields{
-				"api_url":          config.ProxmoxAPIURL,
-				"verify_ssl":       config.ProxmoxVerifySSL,
-				"poll_interval":    config.ProxmoxPollInterval,
-				"interface":        config.ProxmoxInterface,
-				"multi_ipv4":       config.ProxmoxMultiIPv4,
-				"token_configured": config.ProxmoxTokenID != "" && config.ProxmoxTokenSecret != "",
+				"cluster":          cluster.Name,
+				"api_urls":         cluster.APIURLs,
+				"verify_ssl":       cluster.VerifySSL,
+				"poll_interval":    cluster.PollInterval,
+				"interface":        cluster.Interface,
+				"multi_ipv4":       cluster.MultiIPv4,
+				"domain":           cluster.Domain,
+				"record_ttl":       cluster.RecordTTL,
+				"opt_in":           config.ProxmoxOptIn,
+				"token_configured": cluster.TokenID != "" && cluster.TokenSecret != "",
+				"ticket_auth":      cluster.TokenID == "" && cluster.Username != "",
+				"ca_file":          cluster.CAFile,
+				"pinned_certs":     len(cluster.Fingerprints),
 			}).Info("Proxmox configuration loaded")
-		} else {
-			log.Warn("Proxmox mode enabled but no API URL configured")
+		}
+		if config.LeaderElection {
+			log.WithFields(logrus.Fields{
+				"candidate": config.AgentID,
+				"prefix":    config.LeaderElectionPrefix,
+				"ttl":       config.LeaderElectionTTL,
+			}).Info("Leader election enabled for Proxmox monitoring")
 		}
 	}
 }
\ No newline at end of file
@@ -2,61 +2,147 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type DNSAutomator struct {
 	dockerClient *DockerClient
-	proxmoxClient *ProxmoxClient
 	etcdClient   *EtcdClient
-	config       Config
+	views        []View
+	elector      *LeaderElector
+	
+	// reloadMu serializes configuration reloads
+	reloadMu sync.Mutex
+	
+	// mu guards the configuration and the Proxmox monitors, which change on reload
+	mu             sync.Mutex
+	config         Config
+	proxmoxClients []*ProxmoxClient
+	
+	// proxmoxCtx is the context of the running Proxmox monitors, nil while
+	// this instance is not monitoring (for example as election follower)
+	proxmoxCtx     context.Context
+	proxmoxRuns    map[*ProxmoxClient]*proxmoxRun
+	proxmoxErrs    []error
+	proxmoxStopped chan struct{}
 }
 
+// proxmoxRun is the monitor of one Proxmox cluster
+type proxmoxRun struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	started time.Time
+}
 
-func NewDNSAutomator() (*DNSAutomator, error) {
-	config := LoadConfig()
-	
+
+func NewDNSAutomator(config Config) (*DNSAutomator, error) {
 	etcdClient, err := NewEtcdClient(config)
 	if err != nil {
 		return nil, err
 	}
 
-	dockerClient, err := NewDockerClient(etcdClient)
+	views, err := NewViews(config)
 	if err != nil {
 		return nil, err
 	}
 
-	proxmoxClient, err := NewProxmoxClient(etcdClient, config)
+	dockerClient, err := NewDockerClient(etcdClient, views)
 	if err != nil {
 		return nil, err
 	}
 
+	config, err = resolveIdentity(config, dockerClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := etcdClient.Reconfigure(config); err != nil {
+		return nil, err
+	}
+	if err := reconfigureViews(views, config); err != nil {
+		return nil, err
+	}
+
+	var proxmoxClients []*ProxmoxClient
+	if config.AgentMode == "proxmox" || config.AgentMode == "hybrid" {
+		for _, cluster := range config.ProxmoxClusters {
+			proxmoxClient, err := NewProxmoxClient(etcdClient, views, config, cluster)
+			if err != nil {
+				return nil, err
+			}
+			proxmoxClients = append(proxmoxClients, proxmoxClient)
+		}
+	}
+
+	// Clusters may be added by a reload, so the election does not depend on them.
+	// Campaigning writes to etcd and could keep the real leader on standby, so
+	// a dry run never takes part.
+	var elector *LeaderElector
+	if config.LeaderElection && config.DryRun {
+		log.Warn("Dry run: leader election disabled, this instance polls Proxmox without campaigning")
+	}
+	if config.LeaderElection && !config.DryRun && (config.AgentMode == "proxmox" || config.AgentMode == "hybrid") {
+		elector, err = NewLeaderElector(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &DNSAutomator{
-		dockerClient:  dockerClient,
-		proxmoxClient: proxmoxClient,
-		etcdClient:    etcdClient,
-		config:        config,
+		dockerClient:   dockerClient,
+		proxmoxClients: proxmoxClients,
+		etcdClient:     etcdClient,
+		views:          views,
+		elector:        elector,
+		config:         config,
+		proxmoxRuns:    make(map[*ProxmoxClient]*proxmoxRun),
+		proxmoxStopped: make(chan struct{}, 1),
 	}, nil
 }
 
 
 func (da *DNSAutomator) Start() error {
-	log.WithField("mode", da.config.AgentMode).Info("Starting DNSherpa")
+	config := da.currentConfig()
+	log.WithField("mode", config.AgentMode).Info("Starting DNSherpa")
 	
 	ctx := context.Background()
 	
+	// A dry run leaves etcd alone, so there is nothing to repair or warm up
+	if config.DryRun {
+		log.Warn("Dry run: changes are logged and served on /plan instead of written to etcd")
+	}
+	
+	// Repair or report records changed outside DNSherpa
+	if config.WatchPolicy != WatchOff && !config.DryRun {
+		go da.etcdClient.WatchManagedRecords(ctx)
+		for _, view := range da.views {
+			go view.EtcdClient.WatchManagedRecords(ctx)
+		}
+	}
+	
+	// Raise the TTL of new records once they have been stable
+	if config.WarmingTTL > 0 && !config.DryRun {
+		go da.etcdClient.RunWarming(ctx)
+		for _, view := range da.views {
+			go view.EtcdClient.RunWarming(ctx)
+		}
+	}
+	
 	// Start monitoring based on agent mode
-	switch da.config.AgentMode {
+	switch config.AgentMode {
 	case "docker":
 		log.Info("Starting Docker-only monitoring")
 		return da.dockerClient.StartEventMonitoring(ctx)
 		
 	case "proxmox":
 		log.Info("Starting Proxmox-only monitoring")
-		return da.proxmoxClient.StartMonitoring(ctx)
+		return da.startProxmoxMonitoring(ctx)
 		
 	case "hybrid":
 		log.Info("Starting hybrid monitoring (Docker + Proxmox)")
@@ -70,7 +156,7 @@ func (da *DNSAutomator) Start() error {
 		
 		// Start Proxmox monitoring
 		go func() {
-			if err := da.proxmoxClient.StartMonitoring(ctx); err != nil {
+			if err := da.startProxmoxMonitoring(ctx); err != nil {
 				log.WithError(err).Error("Proxmox monitoring failed")
 			}
 		}()
@@ -80,43 +166,220 @@ func (da *DNSAutomator) Start() error {
 		return ctx.Err()
 		
 	default:
-		return fmt.Errorf("invalid agent mode: %s (valid options: docker, proxmox, hybrid)", da.config.AgentMode)
+		return fmt.Errorf("invalid agent mode: %s (valid options: docker, proxmox, hybrid)", config.AgentMode)
+	}
+}
+
+// startProxmoxMonitoring runs an independent monitor per configured cluster and
+// blocks until all of them have stopped
+func (da *DNSAutomator) startProxmoxMonitoring(ctx context.Context) error {
+	da.mu.Lock()
+	configured := len(da.proxmoxClients) > 0
+	da.mu.Unlock()
+	if !configured {
+		log.Info("Proxmox client not configured, waiting for a configuration reload")
+	}
+	
+	if da.elector == nil {
+		return da.monitorProxmoxClusters(ctx)
+	}
+	
+	// Only the leader polls; followers take over when it goes away
+	return da.elector.Run(ctx, da.monitorProxmoxClusters, func() {
+		da.mu.Lock()
+		clients := da.proxmoxClients
+		da.mu.Unlock()
+		for _, pc := range clients {
+			da.etcdClient.ForgetOwner(pc.owner)
+			for _, view := range da.views {
+				view.EtcdClient.ForgetOwner(pc.owner)
+			}
+		}
+	})
+}
+
+// monitorProxmoxClusters runs an independent monitor per configured cluster
+// until ctx is cancelled or all of them have stopped. Clusters added or changed
+// by a reload are started in the same context.
+func (da *DNSAutomator) monitorProxmoxClusters(ctx context.Context) error {
+	da.mu.Lock()
+	da.proxmoxCtx = ctx
+	da.proxmoxErrs = nil
+	for _, pc := range da.proxmoxClients {
+		da.startCluster(pc)
+	}
+	da.mu.Unlock()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			da.mu.Lock()
+			da.proxmoxCtx = nil
+			var runs []*proxmoxRun
+			for _, run := range da.proxmoxRuns {
+				runs = append(runs, run)
+			}
+			da.mu.Unlock()
+			
+			for _, run := range runs {
+				<-run.done
+			}
+			return ctx.Err()
+			
+		case <-da.proxmoxStopped:
+			da.mu.Lock()
+			idle := len(da.proxmoxRuns) == 0 && len(da.proxmoxClients) > 0
+			err := errors.Join(da.proxmoxErrs...)
+			if idle {
+				da.proxmoxCtx = nil
+			}
+			da.mu.Unlock()
+			
+			if idle {
+				return err
+			}
+		}
+	}
+}
+
+// startCluster starts monitoring pc in the current Proxmox context. The caller
+// holds da.mu.
+func (da *DNSAutomator) startCluster(pc *ProxmoxClient) {
+	if da.proxmoxCtx == nil {
+		return
+	}
+	
+	ctx, cancel := context.WithCancel(da.proxmoxCtx)
+	run := &proxmoxRun{cancel: cancel, done: make(chan struct{}), started: time.Now()}
+	da.proxmoxRuns[pc] = run
+	
+	go func() {
+		defer close(run.done)
+		err := pc.StartMonitoring(ctx)
+		
+		da.mu.Lock()
+		defer da.mu.Unlock()
+		
+		// Monitors stopped by a reload are not failures
+		if da.proxmoxRuns[pc] != run {
+			return
+		}
+		delete(da.proxmoxRuns, pc)
+		if ctx.Err() == nil {
+			pc.log.WithError(err).Error("Proxmox cluster monitoring failed")
+			da.proxmoxErrs = append(da.proxmoxErrs, fmt.Errorf("cluster %s: %w", pc.cluster.Name, err))
+		}
+		
+		select {
+		case da.proxmoxStopped <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+// stopCluster stops the monitor of pc, if running, and waits for it to return
+func (da *DNSAutomator) stopCluster(pc *ProxmoxClient) {
+	da.mu.Lock()
+	run, found := da.proxmoxRuns[pc]
+	delete(da.proxmoxRuns, pc)
+	da.mu.Unlock()
+	
+	if found {
+		run.cancel()
+		<-run.done
 	}
 }
 
+func (da *DNSAutomator) currentConfig() Config {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	
+	return da.config
+}
+
 func (da *DNSAutomator) Close() {
+	if da.elector != nil {
+		da.elector.Close()
+	}
 	if da.dockerClient != nil {
 		da.dockerClient.Close()
 	}
 	if da.etcdClient != nil {
 		da.etcdClient.Close()
 	}
+	closeViews(da.views)
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("DNSHERPA_CONFIG"), "path to a YAML or TOML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Parse()
+	
+	// The config file can set the log level and format, so read it first
+	configErr := loadConfigFile(*configPath)
+	
+	// The healthcheck only needs HTTP_LISTEN, which the config file may set
+	if flag.Arg(0) == "healthcheck" {
+		if configErr != nil {
+			fmt.Fprintf(os.Stderr, "healthcheck: %v\n", configErr)
+			os.Exit(1)
+		}
+		os.Exit(runHealthcheck(flag.Args()[1:]))
+	}
+	
 	// Initialize logging first
 	InitializeLogger()
+	if configErr != nil {
+		log.WithError(configErr).Fatal("Failed to load config file")
+	}
 	
-	// Show startup banner
-	ShowStartupBanner()
+	// Keep stdout clean for the printed configuration or plan
+	if *printConfig || flag.Arg(0) == "plan" {
+		log.SetOutput(os.Stderr)
+	}
 	
 	// Load and validate configuration
-	config := LoadConfig()
-	LogConfigurationSummary(config)
+	config, err := LoadConfig()
+	if err != nil {
+		log.WithError(err).Fatal("Invalid configuration")
+	}
+	
+	if flag.Arg(0) == "plan" {
+		os.Exit(runPlan(config, flag.Args()[1:]))
+	}
+	
+	if *printConfig {
+		if err := PrintConfig(os.Stdout, config); err != nil {
+			log.WithError(err).Fatal("Failed to print configuration")
+		}
+		return
+	}
+	
+	// Show startup banner
+	ShowStartupBanner()
 	
 	// Create DNS automator
 	log.Info("Initializing DNS automator...")
-	automator, err := NewDNSAutomator()
+	automator, err := NewDNSAutomator(config)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to create DNS automator. Check your configuration and network connectivity.")
 	}
+	
+	// Exit with the shutdown status once the automator is closed
+	exitCode := 0
+	defer func() {
+		os.Exit(exitCode)
+	}()
 	defer automator.Close()
 	
+	// The summary includes the detected DNS target and agent ID
+	LogConfigurationSummary(automator.currentConfig())
+	
 	log.Info("DNS automator initialized successfully")
 	
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	
 	// Start the automator in a goroutine
 	go func() {
@@ -125,7 +388,48 @@ func main() {
 		}
 	}()
 	
-	// Wait for shutdown signal
-	sig := <-sigChan
-	log.WithField("signal", sig).Info("Received shutdown signal, stopping gracefully...")
+	reload := func() {
+		if err := automator.Reload(*configPath); err != nil {
+			log.WithError(err).Error("Failed to reload configuration, keeping the current one")
+		}
+	}
+	
+	// Serve the status API. A failure stops DNSherpa through the normal
+	// shutdown, so leadership is resigned and the clients are closed.
+	serverErr := make(chan error, 1)
+	if config.HTTPListen != "off" {
+		go func() {
+			serverErr <- automator.RunHTTPServer(context.Background(), config.HTTPListen)
+		}()
+	}
+	
+	// Reload when the config file or a secret file changes
+	go WatchConfigFiles(context.Background(), func() []string {
+		files := automator.currentConfig().SecretFiles
+		if *configPath != "" {
+			files = append([]string{*configPath}, files...)
+		}
+		return files
+	}, reload)
+	
+	// Wait for shutdown signal, reloading on SIGHUP
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				log.Info("Received SIGHUP, reloading configuration")
+				reload()
+				continue
+			}
+			log.WithField("signal", sig).Info("Received shutdown signal, stopping gracefully...")
+			return
+			
+		case err := <-serverErr:
+			if err != nil {
+				log.WithError(err).Error("HTTP server failed, stopping")
+				exitCode = 1
+				return
+			}
+		}
+	}
 }
\ No newline at end of file
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics served on /metrics. etcd is the only DNS provider, so record
+// metrics are labelled with the prefix of the view they were written to.
+var (
+	recordWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnsherpa_etcd_record_writes_total",
+		Help: "DNS records written to etcd.",
+	}, []string{"prefix"})
+
+	recordDeletes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnsherpa_etcd_record_deletes_total",
+		Help: "DNS records deleted from etcd.",
+	}, []string{"prefix"})
+
+	recordFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnsherpa_etcd_record_failures_total",
+		Help: "Failed DNS record writes and deletes.",
+	}, []string{"prefix", "operation"})
+
+	etcdRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dnsherpa_etcd_request_duration_seconds",
+		Help:    "Latency of etcd requests.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"operation"})
+
+	dockerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnsherpa_docker_events_total",
+		Help: "Docker container events processed.",
+	}, []string{"action"})
+
+	proxmoxSyncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dnsherpa_proxmox_sync_duration_seconds",
+		Help:    "Duration of Proxmox cluster syncs.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"cluster"})
+
+	proxmoxAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnsherpa_proxmox_api_requests_total",
+		Help: "Requests sent to Proxmox API endpoints, by status code or \"error\".",
+	}, []string{"cluster", "endpoint", "code"})
+
+	proxmoxGuestsSkipped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dnsherpa_proxmox_guests_skipped",
+		Help: "Proxmox guests not published by the last sync, by reason.",
+	}, []string{"cluster", "reason"})
+
+	syncErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnsherpa_sync_errors_total",
+		Help: "Failed or incomplete syncs per source.",
+	}, []string{"source"})
+
+	lastSuccessfulSync = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dnsherpa_last_successful_sync_timestamp_seconds",
+		Help: "Unix time of the last successful sync or handled Docker event per source.",
+	}, []string{"source"})
+)
+
+// Reasons a Proxmox guest is skipped
+const (
+	skipNotRunning = "not_running"
+	skipTag        = "skip_tag"
+	skipNoIP       = "no_ip"
+	skipFiltered   = "filtered"
+)
+
+// etcdTimer starts timing an etcd request; call the result when it returns
+func etcdTimer(operation string) func() {
+	start := time.Now()
+	return func() {
+		etcdRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// statusCodeLabel is the code label of a Proxmox API response
+func statusCodeLabel(code int, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return strconv.Itoa(code)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Actions of a planned change
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// PlanChange is a change to etcd that a dry run did not make
+type PlanChange struct {
+	Action string          `json:"action"`
+	Key    string          `json:"key"`
+	Old    json.RawMessage `json:"old,omitempty"`
+	New    json.RawMessage `json:"new,omitempty"`
+}
+
+// Plan collects the changes of a dry run, the latest one per key
+type Plan struct {
+	mu      sync.Mutex
+	changes map[string]PlanChange
+}
+
+func NewPlan() *Plan {
+	return &Plan{changes: make(map[string]PlanChange)}
+}
+
+// set stores the pending change of a key and reports whether it differs
+// from the one stored before
+func (p *Plan) set(change PlanChange) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous, found := p.changes[change.Key]
+	p.changes[change.Key] = change
+	return !found || previous.Action != change.Action || string(previous.New) != string(change.New)
+}
+
+// clear drops the pending change of a key that already holds the desired value
+func (p *Plan) clear(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.changes, key)
+}
+
+// Changes returns the pending changes sorted by key
+func (p *Plan) Changes() []PlanChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changes := make([]PlanChange, 0, len(p.changes))
+	for _, change := range p.changes {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// planPut records that value would be written to key, comparing it with the
+// value currently in etcd
+func (ec *EtcdClient) planPut(ctx context.Context, key, value string) error {
+	old, found, err := ec.currentValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	if found && old == value {
+		ec.plan.clear(key)
+		return nil
+	}
+
+	change := PlanChange{Action: PlanCreate, Key: key, New: planValue(value)}
+	if found {
+		change.Action = PlanUpdate
+		change.Old = planValue(old)
+	}
+	ec.logPlanned(change)
+	return nil
+}
+
+// planDelete records that key would be deleted
+func (ec *EtcdClient) planDelete(ctx context.Context, key string) error {
+	old, found, err := ec.currentValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		ec.plan.clear(key)
+		return nil
+	}
+
+	ec.logPlanned(PlanChange{Action: PlanDelete, Key: key, Old: planValue(old)})
+	return nil
+}
+
+// planWrites records the puts and deletes of a transaction
+func (ec *EtcdClient) planWrites(ctx context.Context, keys, values, deletes []string) error {
+	for i, key := range keys {
+		if err := ec.planPut(ctx, key, values[i]); err != nil {
+			return err
+		}
+	}
+	for _, key := range deletes {
+		if err := ec.planDelete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentValue reads the value of key from etcd
+func (ec *EtcdClient) currentValue(ctx context.Context, key string) (string, bool, error) {
+	done := etcdTimer("get")
+	resp, err := ec.etcd().Get(ctx, key)
+	done()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+// logPlanned stores change and logs it unless the same change is already pending
+func (ec *EtcdClient) logPlanned(change PlanChange) {
+	if !ec.plan.set(change) {
+		return
+	}
+	log.WithFields(map[string]interface{}{
+		"action": change.Action,
+		"key":    change.Key,
+		"old":    string(change.Old),
+		"new":    string(change.New),
+	}).Info("Dry run: planned DNS record change")
+}
+
+// planValue embeds a stored value in the plan, as JSON if it is JSON
+func planValue(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
+
+// WritePlan prints changes as a diff, one line per key
+func WritePlan(w io.Writer, changes []PlanChange) {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Action]++
+		switch change.Action {
+		case PlanCreate:
+			fmt.Fprintf(w, "+ %s %s\n", change.Key, change.New)
+		case PlanUpdate:
+			fmt.Fprintf(w, "~ %s\n    - %s\n    + %s\n", change.Key, change.Old, change.New)
+		case PlanDelete:
+			fmt.Fprintf(w, "- %s %s\n", change.Key, change.Old)
+		}
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes. etcd matches the configured sources.")
+		return
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete.\n", counts[PlanCreate], counts[PlanUpdate], counts[PlanDelete])
+}
+
+// PlannedChanges returns the changes a dry run did not make in any view
+func (da *DNSAutomator) PlannedChanges() []PlanChange {
+	changes := []PlanChange{}
+	for _, view := range da.allViews() {
+		if view.EtcdClient.plan != nil {
+			changes = append(changes, view.EtcdClient.plan.Changes()...)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// syncOnce runs a single sync of the Docker containers and every Proxmox
+// cluster, continuing with the other sources when one fails
+func (da *DNSAutomator) syncOnce(ctx context.Context) error {
+	da.mu.Lock()
+	config := da.config
+	clients := da.proxmoxClients
+	da.mu.Unlock()
+
+	var errs []error
+	if config.AgentMode == "docker" || config.AgentMode == "hybrid" {
+		if err := da.dockerClient.SyncExistingContainers(); err != nil {
+			errs = append(errs, fmt.Errorf("docker: %w", err))
+		}
+	}
+	for _, pc := range clients {
+		err := pc.testConnection(ctx)
+		if err == nil {
+			err = pc.runSync(ctx)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", pc.cluster.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runPlan syncs every source once in dry-run mode and prints the changes it
+// would make to etcd, returning the exit code
+func runPlan(config Config, args []string) int {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the changes as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config.DryRun = true
+
+	automator, err := NewDNSAutomator(config)
+	if err != nil {
+		log.WithError(err).Error("Failed to create DNS automator")
+		return 1
+	}
+	defer automator.Close()
+
+	syncErr := automator.syncOnce(context.Background())
+	changes := automator.PlannedChanges()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(changes); err != nil {
+			log.WithError(err).Error("Failed to print plan")
+			return 1
+		}
+	} else {
+		WritePlan(os.Stdout, changes)
+	}
+
+	if syncErr != nil {
+		log.WithError(syncErr).Error("Sync failed, the plan is incomplete")
+		return 1
+	}
+	return 0
+}
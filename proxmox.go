@@ -2,105 +2,176 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/luthermonson/go-proxmox"
+	"github.com/sirupsen/logrus"
 )
 
 type ProxmoxClient struct {
 	client     *proxmox.Client
 	etcdClient *EtcdClient
+	views      []View
 	config     Config
+	cluster    ProxmoxClusterConfig
+	filter     *GuestFilter
+	failover   *failoverTransport
+	owner      string
+	log        *logrus.Entry
+	
+	// ticketIssued is when the current ticket was obtained (ticket auth only)
+	ticketIssued time.Time
+	
+	// resync asks the polling loop to sync before the next tick
+	resync chan struct{}
+	
+	// syncs records the outcome of syncs, origins the guest of every key
+	// published by the last one
+	syncs     syncTracker
+	originsMu sync.Mutex
+	origins   map[string][]RecordOrigin
 }
 
-func NewProxmoxClient(etcdClient *EtcdClient, config Config) (*ProxmoxClient, error) {
-	if config.ProxmoxAPIURL == "" {
-		return &ProxmoxClient{
-			etcdClient: etcdClient,
-			config:     config,
-		}, nil // Return empty client for non-proxmox modes
+func NewProxmoxClient(etcdClient *EtcdClient, views []View, config Config, cluster ProxmoxClusterConfig) (*ProxmoxClient, error) {
+	if len(cluster.APIURLs) == 0 {
+		return nil, fmt.Errorf("no API URL configured for Proxmox cluster %s", cluster.Name)
 	}
 
-	// Ensure API URL has the correct path
-	apiURL := config.ProxmoxAPIURL
-	if !strings.HasSuffix(apiURL, "/api2/json") {
-		// Remove trailing slash if present, then add /api2/json
-		apiURL = strings.TrimSuffix(apiURL, "/") + "/api2/json"
+	clusterLog := log.WithField("cluster", cluster.Name)
+
+	// Ensure API URLs have the correct path
+	apiURLs := make([]string, 0, len(cluster.APIURLs))
+	for _, apiURL := range cluster.APIURLs {
+		if !strings.HasSuffix(apiURL, "/api2/json") {
+			// Remove trailing slash if present, then add /api2/json
+			apiURL = strings.TrimSuffix(apiURL, "/") + "/api2/json"
+		}
+		apiURLs = append(apiURLs, apiURL)
 	}
 
-	log.WithField("api_url", apiURL).Info("Connecting to Proxmox API")
+	clusterLog.WithField("api_urls", apiURLs).Info("Connecting to Proxmox API")
 
-	// Create HTTP client with SSL verification setting
-	httpClient := &http.Client{}
-	if !config.ProxmoxVerifySSL {
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
+	filter, err := NewGuestFilter(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Proxmox guest filter: %w", err)
 	}
 
-	// Create Proxmox client with API token
-	client := proxmox.NewClient(apiURL,
+	authOption, err := proxmoxAuthOption(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials for Proxmox cluster %s: %w", cluster.Name, err)
+	}
+
+	// Create HTTP transport with SSL verification settings
+	tlsConfig, err := buildProxmoxTLSConfig(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build TLS config for Proxmox cluster %s: %w", cluster.Name, err)
+	}
+	baseTransport := http.DefaultTransport.(*http.Transport).Clone()
+	baseTransport.TLSClientConfig = tlsConfig
+
+	// Requests go to the first reachable endpoint of the cluster
+	failover, err := newFailoverTransport(baseTransport, cluster.Name, apiURLs, clusterLog)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API URL for Proxmox cluster %s: %w", cluster.Name, err)
+	}
+	httpClient := &http.Client{Transport: failover}
+
+	// Create Proxmox client with API token or ticket credentials
+	client := proxmox.NewClient(apiURLs[0],
 		proxmox.WithHTTPClient(httpClient),
-		proxmox.WithAPIToken(config.ProxmoxTokenID, config.ProxmoxTokenSecret),
+		authOption,
 	)
 
 	return &ProxmoxClient{
 		client:     client,
 		etcdClient: etcdClient,
+		views:      views,
 		config:     config,
+		cluster:    cluster,
+		filter:     filter,
+		failover:   failover,
+		owner:      "proxmox/" + cluster.Name,
+		log:        clusterLog,
+		resync:     make(chan struct{}, 1),
+		syncs:      syncTracker{status: SyncStatus{Source: "proxmox/" + cluster.Name}},
 	}, nil
 }
 
 func (pc *ProxmoxClient) StartMonitoring(ctx context.Context) error {
-	if pc.client == nil {
-		log.Info("Proxmox client not configured, skipping monitoring")
-		<-ctx.Done()
-		return ctx.Err()
-	}
-
-	log.WithField("poll_interval", pc.config.ProxmoxPollInterval).Info("Starting Proxmox monitoring")
+	pc.log.WithField("poll_interval", pc.cluster.PollInterval).Info("Starting Proxmox monitoring")
 
 	// Test connection
 	if err := pc.testConnection(ctx); err != nil {
+		pc.syncs.record(err)
 		return fmt.Errorf("failed to connect to Proxmox: %w", err)
 	}
 
 	// Initial sync
-	if err := pc.syncAllResources(ctx); err != nil {
-		log.WithError(err).Warn("Initial sync failed")
+	if err := pc.runSync(ctx); err != nil {
+		pc.log.WithError(err).Warn("Initial sync failed")
 	}
 
 	// Start polling loop
-	ticker := time.NewTicker(pc.config.ProxmoxPollInterval)
+	ticker := time.NewTicker(pc.cluster.PollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := pc.syncAllResources(ctx); err != nil {
-				log.WithError(err).Error("Error during Proxmox sync")
-			}
+		case <-pc.resync:
 		case <-ctx.Done():
 			return ctx.Err()
 		}
+		
+		if err := pc.runSync(ctx); err != nil {
+			pc.log.WithError(err).Error("Error during Proxmox sync")
+			
+			// A rejected ticket is renewed on the next sync
+			if proxmox.IsNotAuthorized(err) {
+				pc.ticketIssued = time.Time{}
+			}
+		}
+	}
+}
+
+// runSync runs syncAllResources and records its outcome and duration
+func (pc *ProxmoxClient) runSync(ctx context.Context) error {
+	start := time.Now()
+	err := pc.syncAllResources(ctx)
+	proxmoxSyncDuration.WithLabelValues(pc.cluster.Name).Observe(time.Since(start).Seconds())
+	pc.syncs.record(err)
+	return err
+}
+
+// Resync asks the polling loop to sync right away, for example after the
+// configuration was reloaded
+func (pc *ProxmoxClient) Resync() {
+	select {
+	case pc.resync <- struct{}{}:
+	default:
 	}
 }
 
 func (pc *ProxmoxClient) testConnection(ctx context.Context) error {
-	log.Info("Testing Proxmox API connection...")
+	pc.log.Info("Testing Proxmox API connection...")
+	
+	if err := pc.ensureSession(ctx); err != nil {
+		return err
+	}
 	
 	version, err := pc.client.Version(ctx)
 	if err != nil {
 		return fmt.Errorf("API connection test failed: %w", err)
 	}
 	
-	log.WithFields(map[string]interface{}{
+	pc.log.WithFields(map[string]interface{}{
 		"version": version.Version,
 		"release": version.Release,
 	}).Info("Proxmox API connection successful")
@@ -108,18 +179,18 @@ func (pc *ProxmoxClient) testConnection(ctx context.Context) error {
 	// Test cluster access
 	_, err = pc.client.Cluster(ctx)
 	if err != nil {
-		log.WithError(err).Warn("Cannot access cluster info")
+		pc.log.WithError(err).Warn("Cannot access cluster info")
 	} else {
-		log.Info("Cluster access successful")
+		pc.log.Info("Cluster access successful")
 		
 		// Test basic resource access
 		nodes, err := pc.client.Nodes(ctx)
 		if err != nil {
-			log.WithError(err).Warn("Cannot list nodes")
+			pc.log.WithError(err).Warn("Cannot list nodes")
 		} else {
-			log.WithField("node_count", len(nodes)).Info("Found nodes in cluster")
+			pc.log.WithField("node_count", len(nodes)).Info("Found nodes in cluster")
 			for _, node := range nodes {
-				log.WithFields(map[string]interface{}{
+				pc.log.WithFields(map[string]interface{}{
 					"node_name": node.Node,
 					"status":    node.Status,
 					"type":      node.Type,
@@ -132,7 +203,11 @@ func (pc *ProxmoxClient) testConnection(ctx context.Context) error {
 }
 
 func (pc *ProxmoxClient) syncAllResources(ctx context.Context) error {
-	log.Info("Syncing Proxmox VMs and containers...")
+	pc.log.Info("Syncing Proxmox VMs and containers...")
+	
+	if err := pc.ensureSession(ctx); err != nil {
+		return err
+	}
 	
 	// Get list of nodes first
 	nodes, err := pc.client.Nodes(ctx)
@@ -141,59 +216,108 @@ func (pc *ProxmoxClient) syncAllResources(ctx context.Context) error {
 	}
 
 	var processedCount int
-	var skippedCount int
+	skipped := map[string]int{skipNotRunning: 0, skipTag: 0, skipNoIP: 0, skipFiltered: 0}
+
+	// Keys published in this sync; anything else this cluster owns is stale.
+	// Cleanup only runs when every node and guest could be read.
+	keep := make(map[string]bool)
+	origins := make(map[string][]RecordOrigin)
+	complete := true
+	nodeRead := false // an incomplete sync still counts if any node was read
+	refused := false
+	invalid := false
+
+	// Pool membership is only reported by the cluster resources endpoint
+	var pools map[uint64]string
+	if pc.filter.NeedsPools() {
+		pools, err = pc.getGuestPools(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get guest pools: %w", err)
+		}
+	}
 
 	// Query each node for VMs and containers
 	for _, nodeStatus := range nodes {
 		if nodeStatus.Status != "online" {
-			log.WithField("node", nodeStatus.Node).Warn("Skipping offline node")
+			pc.log.WithField("node", nodeStatus.Node).Warn("Skipping offline node")
+			complete = false
 			continue
 		}
 
-		log.WithField("node", nodeStatus.Node).Debug("Querying VMs on node")
+		pc.log.WithField("node", nodeStatus.Node).Debug("Querying VMs on node")
 		
 		node, err := pc.client.Node(ctx, nodeStatus.Node)
 		if err != nil {
-			log.WithFields(map[string]interface{}{
+			pc.log.WithFields(map[string]interface{}{
 				"node":  nodeStatus.Node,
 				"error": err,
 			}).Error("Failed to get node")
+			complete = false
 			continue
 		}
 
 		// Get QEMU VMs on this node
 		vms, err := node.VirtualMachines(ctx)
 		if err != nil {
-			log.WithFields(map[string]interface{}{
+			pc.log.WithFields(map[string]interface{}{
 				"node":  nodeStatus.Node,
 				"error": err,
 			}).Error("Failed to get VMs on node")
+			complete = false
 		} else {
-			log.WithFields(map[string]interface{}{
+			nodeRead = true
+			pc.log.WithFields(map[string]interface{}{
 				"node":     nodeStatus.Node,
 				"vm_count": len(vms),
 			}).Info("Found QEMU VMs on node")
 			for _, vm := range vms {
-				log.WithFields(map[string]interface{}{
+				pc.log.WithFields(map[string]interface{}{
 					"vm_name": vm.Name,
 					"vmid":    vm.VMID,
 					"status":  vm.Status,
 				}).Debug("Processing VM")
 				
 				if vm.Status != "running" {
-					log.WithFields(map[string]interface{}{
+					pc.log.WithFields(map[string]interface{}{
 						"vm_name": vm.Name,
 						"status":  vm.Status,
 					}).Debug("Skipping non-running VM")
-					skippedCount++
+					skipped[skipNotRunning]++
+					continue
+				}
+
+				resource := vmResource(vm, nodeStatus.Node)
+				resource.Pool = pools[resource.VMID]
+				if allowed, reason := pc.filter.Allow(resource); !allowed {
+					pc.log.WithFields(map[string]interface{}{
+						"vm_name": vm.Name,
+						"reason":  reason,
+					}).Debug("Skipping VM filtered by selection policy")
+					skipped[skipFiltered]++
 					continue
 				}
 
-				if err := pc.processVM(ctx, vm, nodeStatus.Node); err != nil {
-					log.WithFields(map[string]interface{}{
+				keys, err := pc.processGuest(ctx, resource, skipped)
+				pc.trackKeys(keys, resource, keep, origins)
+				if errors.Is(err, errConflictRefused) {
+					pc.log.WithFields(map[string]interface{}{
+						"vm_name": vm.Name,
+						"error":   err,
+					}).Warn("Some names of VM refused by the conflict policy")
+					refused = true
+				} else if errors.Is(err, errInvalidGuestSettings) {
+					pc.log.WithFields(map[string]interface{}{
+						"vm_name": vm.Name,
+						"error":   err,
+					}).Warn("Invalid DNS settings, keeping the records of the previous sync")
+					invalid = true
+					continue
+				} else if err != nil {
+					pc.log.WithFields(map[string]interface{}{
 						"vm_name": vm.Name,
 						"error":   err,
 					}).Error("Error processing VM")
+					complete = false
 					continue
 				}
 				processedCount++
@@ -203,36 +327,65 @@ func (pc *ProxmoxClient) syncAllResources(ctx context.Context) error {
 		// Get LXC containers on this node
 		containers, err := node.Containers(ctx)
 		if err != nil {
-			log.WithFields(map[string]interface{}{
+			pc.log.WithFields(map[string]interface{}{
 				"node":  nodeStatus.Node,
 				"error": err,
 			}).Error("Failed to get containers on node")
+			complete = false
 		} else {
-			log.WithFields(map[string]interface{}{
+			nodeRead = true
+			pc.log.WithFields(map[string]interface{}{
 				"node":            nodeStatus.Node,
 				"container_count": len(containers),
 			}).Info("Found LXC containers on node")
 			for _, container := range containers {
-				log.WithFields(map[string]interface{}{
+				pc.log.WithFields(map[string]interface{}{
 					"container_name": container.Name,
 					"vmid":           container.VMID,
 					"status":         container.Status,
 				}).Debug("Processing container")
 				
 				if container.Status != "running" {
-					log.WithFields(map[string]interface{}{
+					pc.log.WithFields(map[string]interface{}{
 						"container_name": container.Name,
 						"status":         container.Status,
 					}).Debug("Skipping non-running container")
-					skippedCount++
+					skipped[skipNotRunning]++
+					continue
+				}
+
+				resource := containerResource(container, nodeStatus.Node)
+				resource.Pool = pools[resource.VMID]
+				if allowed, reason := pc.filter.Allow(resource); !allowed {
+					pc.log.WithFields(map[string]interface{}{
+						"container_name": container.Name,
+						"reason":         reason,
+					}).Debug("Skipping container filtered by selection policy")
+					skipped[skipFiltered]++
 					continue
 				}
 
-				if err := pc.processContainer(ctx, container, nodeStatus.Node); err != nil {
-					log.WithFields(map[string]interface{}{
+				keys, err := pc.processGuest(ctx, resource, skipped)
+				pc.trackKeys(keys, resource, keep, origins)
+				if errors.Is(err, errConflictRefused) {
+					pc.log.WithFields(map[string]interface{}{
+						"container_name": container.Name,
+						"error":          err,
+					}).Warn("Some names of container refused by the conflict policy")
+					refused = true
+				} else if errors.Is(err, errInvalidGuestSettings) {
+					pc.log.WithFields(map[string]interface{}{
+						"container_name": container.Name,
+						"error":          err,
+					}).Warn("Invalid DNS settings, keeping the records of the previous sync")
+					invalid = true
+					continue
+				} else if err != nil {
+					pc.log.WithFields(map[string]interface{}{
 						"container_name": container.Name,
 						"error":          err,
 					}).Error("Error processing container")
+					complete = false
 					continue
 				}
 				processedCount++
@@ -240,208 +393,381 @@ func (pc *ProxmoxClient) syncAllResources(ctx context.Context) error {
 		}
 	}
 
-	log.WithFields(map[string]interface{}{
+	pc.log.WithFields(map[string]interface{}{
 		"processed": processedCount,
-		"skipped":   skippedCount,
+		"skipped":   skipped[skipNotRunning],
+		"filtered":  skipped[skipFiltered],
 	}).Info("Completed Proxmox resource sync")
-	return nil
-}
+	for reason, count := range skipped {
+		proxmoxGuestsSkipped.WithLabelValues(pc.cluster.Name, reason).Set(float64(count))
+	}
 
-func (pc *ProxmoxClient) processVM(ctx context.Context, vm *proxmox.VirtualMachine, nodeName string) error {
-	// Check for opt-out tag
-	if vm.HasTag("dnsherpa-skip") {
-		log.WithField("vm_name", vm.Name).Info("Skipping VM due to dnsherpa-skip tag")
-		return nil
+	pc.originsMu.Lock()
+	pc.origins = origins
+	pc.originsMu.Unlock()
+
+	if !nodeRead {
+		return errors.New("no node of the cluster could be read")
+	}
+	if !complete {
+		return errIncompleteSync
+	}
+	
+	// Keys include the prefix of their view, so one keep set serves all views
+	errs := []error{pc.etcdClient.RemoveStaleRecords(pc.owner, keep)}
+	for _, view := range pc.views {
+		if err := view.EtcdClient.RemoveStaleRecords(pc.owner, keep); err != nil {
+			errs = append(errs, fmt.Errorf("view %s: %w", view.Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	var warnings []error
+	if refused {
+		warnings = append(warnings, errSyncConflicts)
 	}
+	if invalid {
+		warnings = append(warnings, errInvalidGuestSettings)
+	}
+	return errors.Join(warnings...)
+}
 
-	// Generate hostname
-	hostname := pc.generateHostname(vm.Name)
+// getGuestPools maps guest VMIDs to the pool they belong to
+func (pc *ProxmoxClient) getGuestPools(ctx context.Context) (map[uint64]string, error) {
+	cluster, err := pc.client.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
 	
-	// Get VM tags safely - avoid SplitTags() due to potential nil pointer issues
-	var vmTags []string
-	if vm.Tags != "" {
-		vmTags = strings.Split(vm.Tags, ";")
+	resources, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return nil, err
 	}
 	
-	// Get IP addresses using a simulated cluster resource for compatibility
-	fakeResource := &proxmox.ClusterResource{
+	pools := make(map[uint64]string)
+	for _, resource := range resources {
+		if resource.Pool != "" {
+			pools[resource.VMID] = resource.Pool
+		}
+	}
+	return pools, nil
+}
+
+// vmResource builds a cluster resource for a VM for the shared guest handling
+func vmResource(vm *proxmox.VirtualMachine, nodeName string) *proxmox.ClusterResource {
+	return &proxmox.ClusterResource{
 		Name:   vm.Name,
 		Type:   "qemu",
 		Status: vm.Status,
 		VMID:   uint64(vm.VMID),
 		Node:   nodeName,
+		Tags:   vm.Tags,
 	}
-	
-	ips, err := pc.getResourceIPs(ctx, fakeResource, vmTags)
-	if err != nil {
-		return fmt.Errorf("failed to get IPs for VM %s: %w", vm.Name, err)
+}
+
+// containerResource builds a cluster resource for an LXC container for the shared guest handling
+func containerResource(container *proxmox.Container, nodeName string) *proxmox.ClusterResource {
+	return &proxmox.ClusterResource{
+		Name:   container.Name,
+		Type:   "lxc",
+		Status: container.Status,
+		VMID:   uint64(container.VMID),
+		Node:   nodeName,
+		Tags:   container.Tags,
 	}
+}
 
-	if len(ips) == 0 {
-		log.WithField("vm_name", vm.Name).Warn("No IPs found for VM")
-		return nil
+// trackKeys adds the keys published for a guest to the keep set and origins
+func (pc *ProxmoxClient) trackKeys(keys []string, resource *proxmox.ClusterResource, keep map[string]bool, origins map[string][]RecordOrigin) {
+	origin := RecordOrigin{
+		Source:  "proxmox",
+		Cluster: pc.cluster.Name,
+		Node:    resource.Node,
+		VMID:    resource.VMID,
+		Guest:   resource.Name,
+	}
+	for _, key := range keys {
+		keep[key] = true
+		origins[key] = append(origins[key], origin)
 	}
+}
 
-	// Create DNS records
-	return pc.etcdClient.CreateDNSRecords(hostname, ips)
+// Origins returns the guests each key published by the last sync belongs to
+func (pc *ProxmoxClient) Origins() map[string][]RecordOrigin {
+	pc.originsMu.Lock()
+	defer pc.originsMu.Unlock()
+
+	return pc.origins
 }
 
-func (pc *ProxmoxClient) processContainer(ctx context.Context, container *proxmox.Container, nodeName string) error {
-	// Check for opt-out tag
-	if container.HasTag("dnsherpa-skip") {
-		log.WithField("container_name", container.Name).Info("Skipping container due to dnsherpa-skip tag")
-		return nil
+// previousKeys returns the keys the last sync published for a guest. Before
+// the first sync they are unknown, so the sync is marked incomplete instead.
+func (pc *ProxmoxClient) previousKeys(resource *proxmox.ClusterResource) ([]string, error) {
+	pc.originsMu.Lock()
+	defer pc.originsMu.Unlock()
+
+	if pc.origins == nil {
+		return nil, fmt.Errorf("no IPs found for %s", resource.Name)
+	}
+	var keys []string
+	for key, origins := range pc.origins {
+		for _, origin := range origins {
+			if origin.VMID == resource.VMID {
+				keys = append(keys, key)
+				break
+			}
+		}
 	}
+	return keys, nil
+}
 
-	// Generate hostname
-	hostname := pc.generateHostname(container.Name)
-	
-	// Get container tags safely - avoid SplitTags() due to potential nil pointer issues
-	var containerTags []string
-	if container.Tags != "" {
-		containerTags = strings.Split(container.Tags, ";")
+// keepInvalidGuest returns the keys of the previous sync for a guest whose
+// DNS settings are invalid, so a mistake in the notes of one guest neither
+// deletes its records nor stops the cleanup of the rest of the cluster
+func (pc *ProxmoxClient) keepInvalidGuest(resource *proxmox.ClusterResource, err error) ([]string, error) {
+	keys, prevErr := pc.previousKeys(resource)
+	if prevErr != nil {
+		// Nothing synced yet to keep, so cleanup has to wait
+		return nil, fmt.Errorf("invalid DNS settings for %s: %w", resource.Name, err)
+	}
+	return keys, fmt.Errorf("%s: %v: %w", resource.Name, err, errInvalidGuestSettings)
+}
+
+func (pc *ProxmoxClient) processResource(ctx context.Context, resource *proxmox.ClusterResource, skipped map[string]int) ([]string, error) {
+	if resource.Type != "qemu" && resource.Type != "lxc" {
+		return nil, nil // Skip non-VM resources
 	}
 	
-	// Get IP addresses using a simulated cluster resource for compatibility
-	fakeResource := &proxmox.ClusterResource{
-		Name:   container.Name,
-		Type:   "lxc",
-		Status: container.Status,
-		VMID:   uint64(container.VMID),
-		Node:   nodeName,
+	return pc.processGuest(ctx, resource, skipped)
+}
+
+// processGuest resolves the DNS settings of a VM or container, publishes its
+// records and returns the keys written. Guests it does not publish are
+// counted in skipped by reason.
+func (pc *ProxmoxClient) processGuest(ctx context.Context, resource *proxmox.ClusterResource, skipped map[string]int) ([]string, error) {
+	// Get guest tags safely - avoid SplitTags() due to potential nil pointer issues
+	var tags []string
+	if resource.Tags != "" {
+		tags = strings.Split(resource.Tags, ";")
 	}
 	
-	ips, err := pc.getResourceIPs(ctx, fakeResource, containerTags)
+	// Without the description the guest would lose the settings stored
+	// there and cleanup would delete the records published with them
+	description, err := pc.getGuestDescription(ctx, resource)
 	if err != nil {
-		return fmt.Errorf("failed to get IPs for container %s: %w", container.Name, err)
+		return nil, fmt.Errorf("failed to read description of %s: %w", resource.Name, err)
 	}
-
-	if len(ips) == 0 {
-		log.WithField("container_name", container.Name).Warn("No IPs found for container")
-		return nil
+	
+	settings, err := parseGuestSettings(tags, description)
+	if err == nil {
+		_, err = parseSRVSpecs(settings.SRV)
 	}
-
-	// Create DNS records
-	return pc.etcdClient.CreateDNSRecords(hostname, ips)
-}
-
-func (pc *ProxmoxClient) processResource(ctx context.Context, resource *proxmox.ClusterResource) error {
-	// Get the node
-	node, err := pc.client.Node(ctx, resource.Node)
 	if err != nil {
-		return fmt.Errorf("failed to get node %s: %w", resource.Node, err)
+		return pc.keepInvalidGuest(resource, err)
 	}
-
-	var vmName string
-	var hasDnsherpaSkip bool
-	var vmTags []string
-
-	if resource.Type == "qemu" {
-		vm, err := node.VirtualMachine(ctx, int(resource.VMID))
+	
+	// Check for opt-out
+	if settings.Skip {
+		pc.log.WithField("vm_name", resource.Name).Info("Skipping guest due to dnsherpa-skip setting")
+		skipped[skipTag]++
+		return nil, nil
+	}
+	
+	// Generate hostname (hostname override > guest name)
+	name := resource.Name
+	if settings.Hostname != "" {
+		name = settings.Hostname
+	}
+	hostname := pc.generateHostname(name, settings.Domain)
+	
+	// CNAME target replaces the guest's own addresses
+	var ips []string
+	if settings.Target == "" {
+		ips, err = pc.getResourceIPs(ctx, resource, settings)
 		if err != nil {
-			return fmt.Errorf("failed to get VM %d: %w", resource.VMID, err)
+			return nil, fmt.Errorf("failed to get IPs for %s: %w", resource.Name, err)
 		}
 		
-		vmName = resource.Name
-		hasDnsherpaSkip = vm.HasTag("dnsherpa-skip")
-		
-		// Get all tags by splitting manually
-		vm.SplitTags()
-		if vm.Tags != "" {
-			vmTags = strings.Split(vm.Tags, ";")
+		// The guest agent may be down or the guest rebooting, so keep what
+		// was published for it rather than letting the cleanup delete it
+		if len(ips) == 0 {
+			pc.log.WithField("vm_name", resource.Name).Warn("No IPs found for guest, keeping its records")
+			skipped[skipNoIP]++
+			return pc.previousKeys(resource)
 		}
-		
-	} else if resource.Type == "lxc" {
-		container, err := node.Container(ctx, int(resource.VMID))
-		if err != nil {
-			return fmt.Errorf("failed to get container %d: %w", resource.VMID, err)
+	}
+	
+	// Names refused by CONFLICT_POLICY do not keep the guest out of its views
+	var refused []error
+	keys, err := pc.publishGuest(pc.etcdClient, hostname, settings, ips)
+	if errors.Is(err, errConflictRefused) {
+		refused = append(refused, err)
+	} else if err != nil {
+		return keys, err
+	}
+	
+	// Guests only appear in the views they list
+	for _, view := range pc.views {
+		guestView, found := settings.Views[view.Name]
+		if !found {
+			continue
 		}
 		
-		vmName = resource.Name
-		hasDnsherpaSkip = container.HasTag("dnsherpa-skip")
-		
-		// Get all tags by splitting manually
-		container.SplitTags()
-		if container.Tags != "" {
-			vmTags = strings.Split(container.Tags, ";")
+		viewSettings, viewIPs := settings, ips
+		if len(guestView.IPs) > 0 {
+			viewSettings.Target, viewIPs = "", guestView.IPs
+		} else if guestView.Target != "" {
+			viewSettings.Target, viewIPs = guestView.Target, nil
 		}
 		
-	} else {
-		return nil // Skip non-VM resources
+		written, err := pc.publishGuest(view.EtcdClient, hostname, viewSettings, viewIPs)
+		keys = append(keys, written...)
+		if errors.Is(err, errConflictRefused) {
+			refused = append(refused, fmt.Errorf("view %s: %w", view.Name, err))
+		} else if err != nil {
+			return keys, fmt.Errorf("view %s: %w", view.Name, err)
+		}
 	}
+	
+	return keys, errors.Join(refused...)
+}
 
-	// Check for opt-out tag
-	if hasDnsherpaSkip {
-		log.WithField("vm_name", vmName).Info("Skipping VM due to dnsherpa-skip tag")
-		return nil
+// publishGuest writes the records of a guest through ec, pointing hostname at
+// settings.Target or else at ips, and returns the keys written. Names refused
+// by CONFLICT_POLICY do not stop the other records; they are returned as
+// errConflictRefused once everything else is written.
+func (pc *ProxmoxClient) publishGuest(ec *EtcdClient, hostname string, settings GuestSettings, ips []string) ([]string, error) {
+	ttl := ec.ResolveTTL(hostname, pc.cluster.RecordTTL, settings.TTL)
+	
+	var refused []error
+	failed := func(err error) bool {
+		if errors.Is(err, errConflictRefused) {
+			refused = append(refused, err)
+			return false
+		}
+		return err != nil
 	}
-
-	// Generate hostname
-	hostname := pc.generateHostname(vmName)
 	
-	// Get IP addresses
-	ips, err := pc.getResourceIPs(ctx, resource, vmTags)
+	var keys []string
+	var written []string
+	var err error
+	if settings.Target != "" {
+		written, err = ec.CreateTargetRecord(hostname, settings.Target, ttl, pc.owner)
+	} else {
+		written, err = ec.CreateDNSRecords(hostname, ips, ttl, pc.owner)
+	}
+	keys = append(keys, written...)
+	if failed(err) {
+		return keys, err
+	}
+	
+	// The wildcard answers with the same addresses or target as the hostname
+	if settings.Wildcard {
+		wildcard := wildcardName(hostname)
+		if settings.Target != "" {
+			written, err = ec.CreateTargetRecord(wildcard, settings.Target, ttl, pc.owner)
+		} else {
+			written, err = ec.CreateDNSRecords(wildcard, ips, ttl, pc.owner)
+		}
+		keys = append(keys, written...)
+		if failed(err) {
+			return keys, fmt.Errorf("failed to create wildcard %s: %w", wildcard, err)
+		}
+	}
+	
+	services, err := parseSRVSpecs(settings.SRV)
 	if err != nil {
-		return fmt.Errorf("failed to get IPs for %s: %w", vmName, err)
+		return keys, err
 	}
-
-	if len(ips) == 0 {
-		log.WithField("vm_name", vmName).Warn("No IPs found for VM")
-		return nil
+	written, err = ec.CreateSRVRecords(hostname, services, ttl, pc.owner)
+	keys = append(keys, written...)
+	if failed(err) {
+		return keys, err
 	}
-
-	// Create DNS records
-	return pc.etcdClient.CreateDNSRecords(hostname, ips)
-}
-
-func (pc *ProxmoxClient) hasTagInList(tags []string, tag string) bool {
-	for _, t := range tags {
-		if strings.TrimSpace(t) == tag {
-			return true
+	
+	// A CNAME is stored on the name itself and cannot share it with a TXT record
+	cnames := make(map[string]bool)
+	if settings.Target != "" {
+		cnames[ec.hostKey(hostname)] = true
+		if settings.Wildcard {
+			cnames[ec.hostKey(wildcardName(hostname))] = true
+		}
+	}
+	for _, alias := range settings.Aliases {
+		cnames[ec.hostKey(pc.generateHostname(alias, settings.Domain))] = true
+	}
+	var txtRecords []TXTRecord
+	for _, txt := range txtRecordsFromMap(settings.TXT) {
+		if name := txtName(txt, hostname); cnames[ec.hostKey(name)] {
+			pc.log.WithFields(map[string]interface{}{
+				"hostname": hostname,
+				"name":     name,
+			}).Warn("Skipping TXT record on a CNAME name")
+			continue
+		}
+		txtRecords = append(txtRecords, txt)
+	}
+	written, err = ec.CreateTXTRecords(hostname, txtRecords, ttl, pc.owner)
+	keys = append(keys, written...)
+	if failed(err) {
+		return keys, err
+	}
+	
+	// Extra names are CNAMEs to the primary hostname
+	for _, alias := range settings.Aliases {
+		aliasName := pc.generateHostname(alias, settings.Domain)
+		aliasTTL := ec.ResolveTTL(aliasName, pc.cluster.RecordTTL, settings.TTL)
+		written, err := ec.CreateTargetRecord(aliasName, hostname, aliasTTL, pc.owner)
+		keys = append(keys, written...)
+		if failed(err) {
+			return keys, fmt.Errorf("failed to create alias %s: %w", aliasName, err)
 		}
 	}
-	return false
+	
+	return keys, errors.Join(refused...)
 }
 
-func (pc *ProxmoxClient) getTagValue(tags []string, prefix string) string {
-	for _, t := range tags {
-		t = strings.TrimSpace(t)
-		if strings.HasPrefix(t, prefix+":") {
-			return strings.TrimPrefix(t, prefix+":")
-		}
+// getGuestDescription reads the notes of a VM or container from its config
+func (pc *ProxmoxClient) getGuestDescription(ctx context.Context, resource *proxmox.ClusterResource) (string, error) {
+	var config struct {
+		Description string `json:"description"`
+	}
+	
+	path := fmt.Sprintf("/nodes/%s/%s/%d/config", resource.Node, resource.Type, resource.VMID)
+	if err := pc.client.Get(ctx, path, &config); err != nil {
+		return "", err
 	}
-	return ""
+	
+	return config.Description, nil
 }
 
-func (pc *ProxmoxClient) generateHostname(vmName string) string {
+// generateHostname appends the domain (guest override > cluster config) unless vmName is already FQDN
+func (pc *ProxmoxClient) generateHostname(vmName, domain string) string {
 	hostname := vmName
 	
+	if domain == "" {
+		domain = pc.cluster.Domain
+	}
+	
 	// If VM name is not FQDN and we have a domain, append it
-	if !strings.Contains(hostname, ".") && pc.config.Domain != "" {
-		hostname = hostname + "." + pc.config.Domain
+	if !strings.Contains(hostname, ".") && domain != "" {
+		hostname = hostname + "." + domain
 	}
 	
 	return hostname
 }
 
-func (pc *ProxmoxClient) getResourceIPs(ctx context.Context, resource *proxmox.ClusterResource, tags []string) ([]string, error) {
-	// Check for specific IP tag first (highest priority)
-	if specificIPs := pc.getTagValue(tags, "dnsherpa-ip"); specificIPs != "" {
-		ips := strings.Split(specificIPs, ",")
-		var cleanIPs []string
-		for _, ip := range ips {
-			ip = strings.TrimSpace(ip)
-			if net.ParseIP(ip) != nil {
-				cleanIPs = append(cleanIPs, ip)
-			}
-		}
-		return cleanIPs, nil
+func (pc *ProxmoxClient) getResourceIPs(ctx context.Context, resource *proxmox.ClusterResource, settings GuestSettings) ([]string, error) {
+	// Check for specific IP override first (highest priority)
+	if len(settings.IPs) > 0 {
+		return settings.IPs, nil
 	}
 
-	// Get interface name (per-VM tag > global config > default)
-	interfaceName := pc.getTagValue(tags, "dnsherpa-interface")
+	// Get interface name (per-VM setting > global config > default)
+	interfaceName := settings.Interface
 	if interfaceName == "" {
-		interfaceName = pc.config.ProxmoxInterface
+		interfaceName = pc.cluster.Interface
 	}
 
 	// Get IP addresses from the specified interface
@@ -465,7 +791,7 @@ func (pc *ProxmoxClient) extractIPsFromInterface(ctx context.Context, resource *
 		// Try to get network interfaces from agent
 		interfaces, err := vm.AgentGetNetworkIFaces(ctx)
 		if err != nil {
-			log.WithFields(map[string]interface{}{
+			pc.log.WithFields(map[string]interface{}{
 				"vm_name": resource.Name,
 				"error":   err,
 			}).Debug("QEMU agent not available, falling back to config")
@@ -491,19 +817,19 @@ func (pc *ProxmoxClient) extractIPsFromInterface(ctx context.Context, resource *
 		// Try to get container network interfaces
 		interfaces, err := container.Interfaces(ctx)
 		if err != nil {
-			log.WithFields(map[string]interface{}{
+			pc.log.WithFields(map[string]interface{}{
 				"container_name": resource.Name,
 				"error":          err,
 			}).Debug("Failed to get container interfaces, falling back to config")
 			return pc.extractIPsFromConfig(ctx, resource)
 		}
 		
-		log.WithFields(map[string]interface{}{
+		pc.log.WithFields(map[string]interface{}{
 			"container_name":   resource.Name,
 			"interface_count": len(interfaces),
 		}).Debug("Found container network interfaces")
 		for _, iface := range interfaces {
-			log.WithFields(map[string]interface{}{
+			pc.log.WithFields(map[string]interface{}{
 				"container_name": resource.Name,
 				"interface":      iface.Name,
 				"ipv4":           iface.Inet,
@@ -514,7 +840,7 @@ func (pc *ProxmoxClient) extractIPsFromInterface(ctx context.Context, resource *
 				// Extract IPv4 address (remove CIDR suffix)
 				if iface.Inet != "" && iface.Inet != "127.0.0.1/8" {
 					ipv4 := strings.Split(iface.Inet, "/")[0]
-					log.WithFields(map[string]interface{}{
+					pc.log.WithFields(map[string]interface{}{
 						"container_name": resource.Name,
 						"ipv4":           ipv4,
 					}).Debug("Found IPv4 address")
@@ -524,7 +850,7 @@ func (pc *ProxmoxClient) extractIPsFromInterface(ctx context.Context, resource *
 				// Extract IPv6 address (remove CIDR suffix) 
 				if iface.Inet6 != "" && !strings.HasPrefix(iface.Inet6, "::1/") && !strings.HasPrefix(iface.Inet6, "fe80::") {
 					ipv6 := strings.Split(iface.Inet6, "/")[0]
-					log.WithFields(map[string]interface{}{
+					pc.log.WithFields(map[string]interface{}{
 						"container_name": resource.Name,
 						"ipv6":           ipv6,
 					}).Debug("Found IPv6 address")
@@ -543,7 +869,7 @@ func (pc *ProxmoxClient) extractIPsFromConfig(ctx context.Context, resource *pro
 	// In practice, you might want to parse actual network config or use DHCP reservations
 	
 	// For now, return empty - this will be improved based on actual Proxmox setup needs
-	log.WithField("resource_name", resource.Name).Debug("Unable to determine IP from config (agent not available)")
+	pc.log.WithField("resource_name", resource.Name).Debug("Unable to determine IP from config (agent not available)")
 	return []string{}, nil
 }
 
@@ -565,7 +891,7 @@ func (pc *ProxmoxClient) parseNetworkConfig(netConfig string) []string {
 }
 
 func (pc *ProxmoxClient) applyMultiIPv4Strategy(ips []string) []string {
-	if pc.config.ProxmoxMultiIPv4 == "all" {
+	if pc.cluster.MultiIPv4 == "all" {
 		return ips
 	}
 	
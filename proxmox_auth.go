@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// ticketRenewAfter is how old a Proxmox ticket may get before it is renewed.
+// Proxmox tickets are valid for two hours.
+const ticketRenewAfter = time.Hour
+
+// proxmoxAuthOption selects API token or username/password ticket authentication
+func proxmoxAuthOption(cluster ProxmoxClusterConfig) (proxmox.Option, error) {
+	if cluster.TokenID != "" {
+		return proxmox.WithAPIToken(cluster.TokenID, cluster.TokenSecret), nil
+	}
+
+	if cluster.Username != "" {
+		return proxmox.WithCredentials(proxmoxCredentials(cluster)), nil
+	}
+
+	return nil, errors.New("no API token or username configured")
+}
+
+func proxmoxCredentials(cluster ProxmoxClusterConfig) *proxmox.Credentials {
+	credentials := &proxmox.Credentials{
+		Username: cluster.Username,
+		Password: cluster.Password,
+	}
+
+	// The realm can also be given as part of the username (user@pam)
+	if !strings.Contains(cluster.Username, "@") {
+		credentials.Realm = cluster.Realm
+	}
+
+	return credentials
+}
+
+// ensureSession logs in with the configured credentials when there is no
+// ticket yet or the current one is about to expire. API tokens need no session.
+func (pc *ProxmoxClient) ensureSession(ctx context.Context) error {
+	if pc.cluster.TokenID != "" || pc.cluster.Username == "" {
+		return nil
+	}
+
+	if !pc.ticketIssued.IsZero() && time.Since(pc.ticketIssued) < ticketRenewAfter {
+		return nil
+	}
+
+	if _, err := pc.client.Ticket(ctx, proxmoxCredentials(pc.cluster)); err != nil {
+		return fmt.Errorf("failed to obtain Proxmox ticket: %w", err)
+	}
+	pc.ticketIssued = time.Now()
+
+	pc.log.WithField("username", pc.cluster.Username).Debug("Obtained Proxmox ticket")
+	return nil
+}
+
+// buildProxmoxTLSConfig verifies the Proxmox certificate against a custom CA or
+// pinned fingerprints, or skips verification when VerifySSL is disabled
+func buildProxmoxTLSConfig(cluster ProxmoxClusterConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	// Load CA certificate if provided
+	if cluster.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cluster.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	// Pinned fingerprints replace chain and hostname verification
+	if len(cluster.Fingerprints) > 0 {
+		pinned := make(map[string]bool)
+		for _, fingerprint := range cluster.Fingerprints {
+			normalized := normalizeFingerprint(fingerprint)
+			if len(normalized) != sha256.Size*2 {
+				return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+			}
+			pinned[normalized] = true
+		}
+
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !pinned[hex.EncodeToString(sum[:])] {
+				return fmt.Errorf("server certificate fingerprint %s is not pinned", hex.EncodeToString(sum[:]))
+			}
+			return nil
+		}
+		return tlsConfig, nil
+	}
+
+	if !cluster.VerifySSL && cluster.CAFile == "" {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// normalizeFingerprint accepts the AA:BB:... form shown by Proxmox as well as plain hex
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
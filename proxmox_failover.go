@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// endpointRetryAfter is how long a failed API endpoint is avoided before it is tried again
+const endpointRetryAfter = time.Minute
+
+// EndpointStatus is a snapshot of the health of one Proxmox API endpoint
+type EndpointStatus struct {
+	URL       string     `json:"url"`
+	Active    bool       `json:"active"`
+	Healthy   bool       `json:"healthy"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"last_error,omitempty"`
+	DownUntil *time.Time `json:"down_until,omitempty"`
+}
+
+type apiEndpoint struct {
+	url       *url.URL
+	failures  int
+	lastError error
+	downUntil time.Time
+}
+
+// failoverTransport sends Proxmox API requests to one endpoint of the cluster
+// and moves to the next endpoint when it cannot be reached. The endpoint that
+// last worked stays preferred, so traffic does not flap back to a node that
+// just came back from maintenance.
+type failoverTransport struct {
+	base      http.RoundTripper
+	cluster   string
+	log       *logrus.Entry
+	mu        sync.Mutex
+	endpoints []*apiEndpoint
+	active    int
+}
+
+func newFailoverTransport(base http.RoundTripper, cluster string, apiURLs []string, log *logrus.Entry) (*failoverTransport, error) {
+	if len(apiURLs) == 0 {
+		return nil, errors.New("no API endpoints configured")
+	}
+
+	transport := &failoverTransport{
+		base:    base,
+		cluster: cluster,
+		log:     log,
+	}
+	for _, apiURL := range apiURLs {
+		parsed, err := url.Parse(apiURL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid API URL %q", apiURL)
+		}
+		transport.endpoints = append(transport.endpoints, &apiEndpoint{url: parsed})
+	}
+
+	return transport, nil
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var lastErr error
+	for _, index := range t.candidates() {
+		endpoint := t.endpoints[index]
+
+		attempt := req.Clone(req.Context())
+		attempt.URL.Scheme = endpoint.url.Scheme
+		attempt.URL.Host = endpoint.url.Host
+		attempt.Host = endpoint.url.Host
+		if req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attempt)
+		code := 0
+		if resp != nil {
+			code = resp.StatusCode
+		}
+		proxmoxAPIRequests.WithLabelValues(t.cluster, endpoint.url.Host, statusCodeLabel(code, err)).Inc()
+		if err == nil && !isGatewayFailure(resp.StatusCode) {
+			t.markHealthy(index)
+			return resp, nil
+		}
+
+		// The caller gave up, this says nothing about the endpoint
+		if req.Context().Err() != nil {
+			if err == nil {
+				return resp, nil
+			}
+			return nil, err
+		}
+
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("endpoint returned %s", resp.Status)
+		}
+		t.markFailed(index, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("all Proxmox API endpoints failed: %w", lastErr)
+}
+
+// candidates returns endpoint indices to try: the active endpoint first, then
+// the remaining healthy ones, and finally endpoints still in their retry delay
+// as a last resort
+func (t *failoverTransport) candidates() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	order := []int{t.active}
+	var down []int
+	for offset := 1; offset < len(t.endpoints); offset++ {
+		index := (t.active + offset) % len(t.endpoints)
+		if now.Before(t.endpoints[index].downUntil) {
+			down = append(down, index)
+		} else {
+			order = append(order, index)
+		}
+	}
+
+	return append(order, down...)
+}
+
+func (t *failoverTransport) markHealthy(index int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	endpoint := t.endpoints[index]
+	if index != t.active {
+		t.log.WithFields(map[string]interface{}{
+			"from": t.endpoints[t.active].url.Host,
+			"to":   endpoint.url.Host,
+		}).Warn("Failed over to another Proxmox API endpoint")
+		t.active = index
+	}
+	endpoint.failures = 0
+	endpoint.lastError = nil
+	endpoint.downUntil = time.Time{}
+}
+
+func (t *failoverTransport) markFailed(index int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	endpoint := t.endpoints[index]
+	endpoint.failures++
+	endpoint.lastError = err
+	endpoint.downUntil = time.Now().Add(endpointRetryAfter)
+
+	t.log.WithFields(map[string]interface{}{
+		"endpoint": endpoint.url.Host,
+		"failures": endpoint.failures,
+		"error":    err,
+	}).Warn("Proxmox API endpoint unavailable")
+}
+
+// Status returns the health of every configured endpoint
+func (t *failoverTransport) Status() []EndpointStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]EndpointStatus, 0, len(t.endpoints))
+	for i, endpoint := range t.endpoints {
+		status := EndpointStatus{
+			URL:      endpoint.url.String(),
+			Active:   i == t.active,
+			Healthy:  !now.Before(endpoint.downUntil),
+			Failures: endpoint.failures,
+		}
+		if endpoint.lastError != nil {
+			status.LastError = endpoint.lastError.Error()
+			downUntil := endpoint.downUntil
+			status.DownUntil = &downUntil
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// isGatewayFailure reports whether the status code means the node could not
+// serve the request at all, as opposed to an API error for this request
+func isGatewayFailure(statusCode int) bool {
+	return statusCode == http.StatusBadGateway ||
+		statusCode == http.StatusServiceUnavailable ||
+		statusCode == http.StatusGatewayTimeout
+}
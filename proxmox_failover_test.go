@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newTestTransport(t *testing.T, base http.RoundTripper) *failoverTransport {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	transport, err := newFailoverTransport(base, "lab", []string{
+		"https://pve1:8006/api2/json",
+		"https://pve2:8006/api2/json",
+		"https://pve3:8006/api2/json",
+	}, logrus.NewEntry(log))
+	if err != nil {
+		t.Fatalf("newFailoverTransport() error = %v", err)
+	}
+	return transport
+}
+
+func TestFailoverTransportCandidates(t *testing.T) {
+	transport := newTestTransport(t, nil)
+
+	if got, want := transport.candidates(), []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("candidates() = %v, want %v", got, want)
+	}
+
+	// A failed endpoint moves to the back until its retry delay passes
+	transport.markFailed(1, errors.New("connection refused"))
+	if got, want := transport.candidates(), []int{0, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("candidates() after failure = %v, want %v", got, want)
+	}
+
+	// The endpoint that last worked stays preferred
+	transport.markHealthy(2)
+	if got, want := transport.candidates(), []int{2, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("candidates() after failover = %v, want %v", got, want)
+	}
+}
+
+func TestFailoverTransportRoundTrip(t *testing.T) {
+	var hosts []string
+	transport := newTestTransport(t, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		switch req.URL.Host {
+		case "pve1:8006":
+			return nil, errors.New("connection refused")
+		case "pve2:8006":
+			return &http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway", Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://pve1:8006/api2/json/version", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if want := []string{"pve1:8006", "pve2:8006", "pve3:8006"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("RoundTrip() tried %v, want %v", hosts, want)
+	}
+
+	// The next request goes straight to the endpoint that answered
+	hosts = nil
+	resp, err = transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	resp.Body.Close()
+	if want := []string{"pve3:8006"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("RoundTrip() tried %v, want %v", hosts, want)
+	}
+}
+
+func TestFailoverTransportAllFailed(t *testing.T) {
+	transport := newTestTransport(t, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://pve1:8006/api2/json/version", nil)
+	if _, err := transport.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "all Proxmox API endpoints failed") {
+		t.Errorf("RoundTrip() error = %v, want all endpoints failed", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// GuestFilter decides which Proxmox guests are published before any DNS work is done.
+// Every configured include rule must match, and any matching exclude rule rejects the guest.
+type GuestFilter struct {
+	optInTag     string
+	includePools []string
+	excludePools []string
+	includeNodes []string
+	excludeNodes []string
+	includeVMIDs []vmidRange
+	excludeVMIDs []vmidRange
+	includeName  *regexp.Regexp
+	excludeName  *regexp.Regexp
+	includeTags  []string
+	excludeTags  []string
+}
+
+type vmidRange struct {
+	from uint64
+	to   uint64
+}
+
+func NewGuestFilter(config Config) (*GuestFilter, error) {
+	filter := &GuestFilter{
+		includePools: config.ProxmoxIncludePools,
+		excludePools: config.ProxmoxExcludePools,
+		includeNodes: config.ProxmoxIncludeNodes,
+		excludeNodes: config.ProxmoxExcludeNodes,
+		includeTags:  config.ProxmoxIncludeTags,
+		excludeTags:  config.ProxmoxExcludeTags,
+	}
+
+	if config.ProxmoxOptIn {
+		filter.optInTag = config.ProxmoxOptInTag
+	}
+
+	var err error
+	if filter.includeVMIDs, err = parseVMIDRanges(config.ProxmoxIncludeVMIDs); err != nil {
+		return nil, fmt.Errorf("invalid PROXMOX_INCLUDE_VMIDS: %w", err)
+	}
+	if filter.excludeVMIDs, err = parseVMIDRanges(config.ProxmoxExcludeVMIDs); err != nil {
+		return nil, fmt.Errorf("invalid PROXMOX_EXCLUDE_VMIDS: %w", err)
+	}
+
+	if config.ProxmoxIncludeName != "" {
+		if filter.includeName, err = regexp.Compile(config.ProxmoxIncludeName); err != nil {
+			return nil, fmt.Errorf("invalid PROXMOX_INCLUDE_NAME: %w", err)
+		}
+	}
+	if config.ProxmoxExcludeName != "" {
+		if filter.excludeName, err = regexp.Compile(config.ProxmoxExcludeName); err != nil {
+			return nil, fmt.Errorf("invalid PROXMOX_EXCLUDE_NAME: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// NeedsPools reports whether pool membership must be looked up before filtering
+func (f *GuestFilter) NeedsPools() bool {
+	return len(f.includePools) > 0 || len(f.excludePools) > 0
+}
+
+// Allow reports whether the guest should be published, and if not, why
+func (f *GuestFilter) Allow(guest *proxmox.ClusterResource) (bool, string) {
+	var tags []string
+	if guest.Tags != "" {
+		tags = strings.Split(guest.Tags, ";")
+	}
+
+	if f.optInTag != "" && !containsString(tags, f.optInTag) {
+		return false, "missing opt-in tag " + f.optInTag
+	}
+
+	// Include rules
+	if len(f.includePools) > 0 && !containsString(f.includePools, guest.Pool) {
+		return false, "pool not included"
+	}
+	if len(f.includeNodes) > 0 && !containsString(f.includeNodes, guest.Node) {
+		return false, "node not included"
+	}
+	if len(f.includeVMIDs) > 0 && !vmidInRanges(f.includeVMIDs, guest.VMID) {
+		return false, "vmid not included"
+	}
+	if f.includeName != nil && !f.includeName.MatchString(guest.Name) {
+		return false, "name not included"
+	}
+	if len(f.includeTags) > 0 && !containsAny(tags, f.includeTags) {
+		return false, "tags not included"
+	}
+
+	// Exclude rules
+	if guest.Pool != "" && containsString(f.excludePools, guest.Pool) {
+		return false, "pool excluded"
+	}
+	if containsString(f.excludeNodes, guest.Node) {
+		return false, "node excluded"
+	}
+	if vmidInRanges(f.excludeVMIDs, guest.VMID) {
+		return false, "vmid excluded"
+	}
+	if f.excludeName != nil && f.excludeName.MatchString(guest.Name) {
+		return false, "name excluded"
+	}
+	if containsAny(tags, f.excludeTags) {
+		return false, "tag excluded"
+	}
+
+	return true, ""
+}
+
+// parseVMIDRanges parses a list such as "100-199,250" into inclusive ranges
+func parseVMIDRanges(values []string) ([]vmidRange, error) {
+	var ranges []vmidRange
+	for _, value := range values {
+		fromStr, toStr, isRange := strings.Cut(value, "-")
+		if !isRange {
+			toStr = fromStr
+		}
+
+		from, err := strconv.ParseUint(strings.TrimSpace(fromStr), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VMID %q", value)
+		}
+		to, err := strconv.ParseUint(strings.TrimSpace(toStr), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VMID %q", value)
+		}
+		if to < from {
+			return nil, fmt.Errorf("invalid VMID range %q: end is before start", value)
+		}
+
+		ranges = append(ranges, vmidRange{from: from, to: to})
+	}
+	return ranges, nil
+}
+
+func vmidInRanges(ranges []vmidRange, vmid uint64) bool {
+	for _, r := range ranges {
+		if vmid >= r.from && vmid <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, value := range values {
+		if containsString(list, value) {
+			return true
+		}
+	}
+	return false
+}
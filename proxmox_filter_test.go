@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+func TestGuestFilterAllow(t *testing.T) {
+	filter, err := NewGuestFilter(Config{
+		ProxmoxOptIn:        true,
+		ProxmoxOptInTag:     "dns",
+		ProxmoxIncludeNodes: []string{"pve1", "pve2"},
+		ProxmoxExcludeVMIDs: []string{"900-999"},
+		ProxmoxExcludeName:  "^tmp-",
+		ProxmoxExcludeTags:  []string{"private"},
+	})
+	if err != nil {
+		t.Fatalf("NewGuestFilter() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		guest   proxmox.ClusterResource
+		allowed bool
+		reason  string
+	}{
+		{name: "published", guest: proxmox.ClusterResource{Name: "web", Node: "pve1", VMID: 100, Tags: "dns;prod"}, allowed: true},
+		{name: "missing opt-in tag", guest: proxmox.ClusterResource{Name: "web", Node: "pve1", VMID: 100, Tags: "prod"}, reason: "missing opt-in tag dns"},
+		{name: "other node", guest: proxmox.ClusterResource{Name: "web", Node: "pve3", VMID: 100, Tags: "dns"}, reason: "node not included"},
+		{name: "vmid in excluded range", guest: proxmox.ClusterResource{Name: "web", Node: "pve2", VMID: 950, Tags: "dns"}, reason: "vmid excluded"},
+		{name: "excluded name", guest: proxmox.ClusterResource{Name: "tmp-build", Node: "pve1", VMID: 100, Tags: "dns"}, reason: "name excluded"},
+		{name: "excluded tag", guest: proxmox.ClusterResource{Name: "web", Node: "pve1", VMID: 100, Tags: "dns;private"}, reason: "tag excluded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, reason := filter.Allow(&tt.guest)
+			if allowed != tt.allowed || reason != tt.reason {
+				t.Errorf("Allow() = %v, %q, want %v, %q", allowed, reason, tt.allowed, tt.reason)
+			}
+		})
+	}
+}
+
+func TestNewGuestFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "reversed vmid range", config: Config{ProxmoxIncludeVMIDs: []string{"199-100"}}},
+		{name: "vmid not a number", config: Config{ProxmoxExcludeVMIDs: []string{"abc"}}},
+		{name: "invalid name pattern", config: Config{ProxmoxIncludeName: "("}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGuestFilter(tt.config); err == nil {
+				t.Error("NewGuestFilter() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GuestSettings holds the per-guest DNS options collected from Proxmox tags
+// and the dnsherpa: block in the guest description
+type GuestSettings struct {
+	Skip      bool     `yaml:"skip"`
+	Hostname  string   `yaml:"hostname"`
+	Domain    string   `yaml:"domain"`
+	Aliases   []string `yaml:"aliases"`
+	TTL       int      `yaml:"ttl"`
+	Target    string   `yaml:"target"`
+	IPs       []string `yaml:"ips"`
+	Interface string   `yaml:"interface"`
+	SRV       []string `yaml:"srv"`
+	Wildcard  bool     `yaml:"wildcard"`
+	
+	// TXT maps record names ("@" for the hostname itself) to their text
+	TXT map[string]string `yaml:"txt"`
+	
+	// Views lists the split-horizon views the guest is also published in
+	Views map[string]GuestView `yaml:"views"`
+}
+
+// GuestView overrides the addresses of a guest in one view. Without a target
+// or IPs the guest is published with the same records as in the default view.
+type GuestView struct {
+	Target string   `yaml:"target"`
+	IPs    []string `yaml:"ips"`
+}
+
+// descriptionSettings is the YAML document embedded in a guest description
+type descriptionSettings struct {
+	DNSherpa GuestSettings `yaml:"dnsherpa"`
+}
+
+// parseGuestSettings merges the description block with the guest tags.
+// Tags are applied last so they always win over the description.
+func parseGuestSettings(tags []string, description string) (GuestSettings, error) {
+	var settings GuestSettings
+
+	if block := extractDescriptionBlock(description); block != "" {
+		var doc descriptionSettings
+		if err := yaml.Unmarshal([]byte(block), &doc); err != nil {
+			return settings, fmt.Errorf("invalid dnsherpa block in description: %w", err)
+		}
+		settings = doc.DNSherpa
+	}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "dnsherpa-skip" {
+			settings.Skip = true
+			continue
+		}
+		if tag == "dnsherpa-wildcard" {
+			settings.Wildcard = true
+			continue
+		}
+
+		name, value, found := strings.Cut(tag, ":")
+		if !found || !strings.HasPrefix(name, "dnsherpa-") {
+			continue
+		}
+
+		// dnsherpa-view-<name> takes a CNAME target or a list of IPs
+		if view, isView := strings.CutPrefix(name, "dnsherpa-view-"); isView {
+			if settings.Views == nil {
+				settings.Views = make(map[string]GuestView)
+			}
+			settings.Views[view] = parseGuestView(value)
+			continue
+		}
+
+		switch name {
+		case "dnsherpa-hostname":
+			settings.Hostname = value
+		case "dnsherpa-domain":
+			settings.Domain = value
+		case "dnsherpa-alias":
+			settings.Aliases = append(settings.Aliases, splitList(value)...)
+		case "dnsherpa-ttl":
+			ttl, err := strconv.Atoi(value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid dnsherpa-ttl tag %q: %w", value, err)
+			}
+			settings.TTL = ttl
+		case "dnsherpa-target":
+			settings.Target = value
+		case "dnsherpa-ip":
+			settings.IPs = splitList(value)
+		case "dnsherpa-interface":
+			settings.Interface = value
+		case "dnsherpa-srv":
+			settings.SRV = append(settings.SRV, splitList(value)...)
+		case "dnsherpa-txt":
+			if settings.TXT == nil {
+				settings.TXT = make(map[string]string)
+			}
+			settings.TXT["@"] = value
+		}
+	}
+
+	if settings.TTL < 0 {
+		return settings, fmt.Errorf("invalid TTL %d: must not be negative", settings.TTL)
+	}
+
+	// Only keep well-formed IP overrides
+	settings.IPs = validIPs(settings.IPs)
+
+	for name, view := range settings.Views {
+		view.IPs = validIPs(view.IPs)
+		settings.Views[name] = view
+	}
+
+	return settings, nil
+}
+
+// parseGuestView reads the value of a dnsherpa-view-<name> tag
+func parseGuestView(value string) GuestView {
+	items := splitList(value)
+	if len(items) > 0 && len(validIPs(items)) == len(items) {
+		return GuestView{IPs: items}
+	}
+	return GuestView{Target: value}
+}
+
+// validIPs returns the well-formed addresses of ips, trimmed of spaces
+func validIPs(ips []string) []string {
+	var valid []string
+	for _, ip := range ips {
+		ip = strings.TrimSpace(ip)
+		if net.ParseIP(ip) != nil {
+			valid = append(valid, ip)
+		}
+	}
+	return valid
+}
+
+// extractDescriptionBlock returns the top-level dnsherpa: key of a guest
+// description together with its indented body. Any other notes in the
+// description are ignored so the block can live next to free text.
+func extractDescriptionBlock(description string) string {
+	lines := strings.Split(strings.ReplaceAll(description, "\r\n", "\n"), "\n")
+
+	var block []string
+	inBlock := false
+	for _, line := range lines {
+		if !inBlock {
+			if strings.HasPrefix(line, "dnsherpa:") {
+				inBlock = true
+				block = append(block, line)
+			}
+			continue
+		}
+
+		// The block ends at the first non-indented, non-empty line
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			break
+		}
+		block = append(block, line)
+	}
+
+	return strings.Join(block, "\n")
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGuestSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        []string
+		description string
+		want        GuestSettings
+		wantErr     bool
+	}{
+		{name: "empty", want: GuestSettings{}},
+		{
+			name:        "description block",
+			description: "Web server\n\ndnsherpa:\n  hostname: web\n  aliases: [www, app]\n  ttl: 60\n  ips: [10.0.0.5, not-an-ip]\n  txt:\n    \"@\": v=spf1 -all\nOther notes",
+			want: GuestSettings{
+				Hostname: "web",
+				Aliases:  []string{"www", "app"},
+				TTL:      60,
+				IPs:      []string{"10.0.0.5"},
+				TXT:      map[string]string{"@": "v=spf1 -all"},
+			},
+		},
+		{
+			name:        "tags override the description",
+			tags:        []string{"dnsherpa-hostname:api", " dnsherpa-ttl:30", "dnsherpa-alias:a,b", "dnsherpa-skip", "production"},
+			description: "dnsherpa:\n  hostname: web\n  ttl: 60\n  aliases: [www]",
+			want: GuestSettings{
+				Skip:     true,
+				Hostname: "api",
+				Aliases:  []string{"www", "a", "b"},
+				TTL:      30,
+			},
+		},
+		{
+			name: "views",
+			tags: []string{"dnsherpa-view-external:edge.example.com", "dnsherpa-view-vpn:10.8.0.5,10.8.0.6"},
+			want: GuestSettings{Views: map[string]GuestView{
+				"external": {Target: "edge.example.com"},
+				"vpn":      {IPs: []string{"10.8.0.5", "10.8.0.6"}},
+			}},
+		},
+		{
+			name:        "description ips with spaces",
+			description: "dnsherpa:\n  ips: [\" 10.0.0.5\", \"10.0.0.6 \"]\n  views:\n    vpn:\n      ips: [\" 10.8.0.5 \"]",
+			want: GuestSettings{
+				IPs:   []string{"10.0.0.5", "10.0.0.6"},
+				Views: map[string]GuestView{"vpn": {IPs: []string{"10.8.0.5"}}},
+			},
+		},
+		{name: "invalid ttl tag", tags: []string{"dnsherpa-ttl:soon"}, wantErr: true},
+		{name: "negative ttl", description: "dnsherpa:\n  ttl: -1", wantErr: true},
+		{name: "invalid block", description: "dnsherpa:\n  aliases: {", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGuestSettings(tt.tags, tt.description)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGuestSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGuestSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 5 * time.Second
+
+// restartSetting is a setting that only takes effect after a restart
+type restartSetting struct {
+	name  string
+	value func(Config) interface{}
+}
+
+// restartSettings cannot change while DNSherpa runs: they determine the etcd
+// connection, the record owner and which background tasks were started
+var restartSettings = []restartSetting{
+	{"AGENT_MODE", func(c Config) interface{} { return c.AgentMode }},
+	{"AGENT_ID", func(c Config) interface{} { return c.AgentID }},
+	{"ETCD_ENDPOINTS", func(c Config) interface{} { return c.EtcdEndpoints }},
+	{"ETCD_PREFIX", func(c Config) interface{} { return c.EtcdPrefix }},
+	{"ETCD_TLS", func(c Config) interface{} { return c.EtcdTLS }},
+	{"ETCD_CERT_FILE", func(c Config) interface{} { return c.EtcdCertFile }},
+	{"ETCD_KEY_FILE", func(c Config) interface{} { return c.EtcdKeyFile }},
+	{"ETCD_CA_FILE", func(c Config) interface{} { return c.EtcdCAFile }},
+	{"DRY_RUN", func(c Config) interface{} { return c.DryRun }},
+	{"LEADER_ELECTION", func(c Config) interface{} { return c.LeaderElection }},
+	{"LEADER_ELECTION_PREFIX", func(c Config) interface{} { return c.LeaderElectionPrefix }},
+	{"LEADER_ELECTION_TTL", func(c Config) interface{} { return c.LeaderElectionTTL }},
+	{"VIEWS", func(c Config) interface{} { return c.Views }},
+	{"HTTP_LISTEN", func(c Config) interface{} { return c.HTTPListen }},
+	{"WATCH_POLICY=off", func(c Config) interface{} { return c.WatchPolicy == WatchOff }},
+	{"WARMING_TTL=0", func(c Config) interface{} { return c.WarmingTTL == 0 }},
+}
+
+// keepRestartSettings returns config with the settings that need a restart
+// taken from running, warning about each one that changed
+func keepRestartSettings(running, config Config) Config {
+	for _, setting := range restartSettings {
+		if !reflect.DeepEqual(setting.value(running), setting.value(config)) {
+			log.WithField("setting", setting.name).Warn("Setting changed, restart DNSherpa to apply it")
+		}
+	}
+
+	config.AgentMode = running.AgentMode
+	config.AgentID = running.AgentID
+	config.EtcdEndpoints = running.EtcdEndpoints
+	config.EtcdPrefix = running.EtcdPrefix
+	config.EtcdTLS = running.EtcdTLS
+	config.EtcdCertFile = running.EtcdCertFile
+	config.EtcdKeyFile = running.EtcdKeyFile
+	config.EtcdCAFile = running.EtcdCAFile
+	config.DryRun = running.DryRun
+	config.LeaderElection = running.LeaderElection
+	config.LeaderElectionPrefix = running.LeaderElectionPrefix
+	config.LeaderElectionTTL = running.LeaderElectionTTL
+	config.Views = running.Views
+	config.HTTPListen = running.HTTPListen
+	if (running.WatchPolicy == WatchOff) != (config.WatchPolicy == WatchOff) {
+		config.WatchPolicy = running.WatchPolicy
+	}
+	if (running.WarmingTTL == 0) != (config.WarmingTTL == 0) {
+		config.WarmingTTL = running.WarmingTTL
+	}
+	return config
+}
+
+// guestFilterConfig returns the settings of config that NewGuestFilter uses
+func guestFilterConfig(c Config) Config {
+	return Config{
+		ProxmoxOptIn:        c.ProxmoxOptIn,
+		ProxmoxOptInTag:     c.ProxmoxOptInTag,
+		ProxmoxIncludePools: c.ProxmoxIncludePools,
+		ProxmoxExcludePools: c.ProxmoxExcludePools,
+		ProxmoxIncludeNodes: c.ProxmoxIncludeNodes,
+		ProxmoxExcludeNodes: c.ProxmoxExcludeNodes,
+		ProxmoxIncludeVMIDs: c.ProxmoxIncludeVMIDs,
+		ProxmoxExcludeVMIDs: c.ProxmoxExcludeVMIDs,
+		ProxmoxIncludeName:  c.ProxmoxIncludeName,
+		ProxmoxExcludeName:  c.ProxmoxExcludeName,
+		ProxmoxIncludeTags:  c.ProxmoxIncludeTags,
+		ProxmoxExcludeTags:  c.ProxmoxExcludeTags,
+	}
+}
+
+// Reload reads the config file at path and the environment again and applies
+// the result. Proxmox clusters whose settings changed are reconnected, removed
+// clusters are stopped and their records deleted, and all sources resync. If
+// the new configuration is invalid the running one stays in effect.
+func (da *DNSAutomator) Reload(path string) error {
+	da.reloadMu.Lock()
+	defer da.reloadMu.Unlock()
+
+	settings, origins, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	previousSettings, previousOrigins := fileSettings, fileOrigins
+	fileSettings, fileOrigins = settings, origins
+	restore := func() {
+		fileSettings, fileOrigins = previousSettings, previousOrigins
+	}
+
+	config, err := LoadConfig()
+	if err == nil {
+		config, err = resolveIdentity(config, da.dockerClient)
+	}
+	if err != nil {
+		restore()
+		return err
+	}
+
+	running := da.currentConfig()
+	config = keepRestartSettings(running, config)
+
+	// Build all new clients before touching the running ones
+	da.mu.Lock()
+	current := make(map[string]*ProxmoxClient, len(da.proxmoxClients))
+	for _, pc := range da.proxmoxClients {
+		current[pc.cluster.Name] = pc
+	}
+	da.mu.Unlock()
+
+	filterChanged := !reflect.DeepEqual(guestFilterConfig(running), guestFilterConfig(config))
+	var clients, started []*ProxmoxClient
+	if config.AgentMode == "proxmox" || config.AgentMode == "hybrid" {
+		for _, cluster := range config.ProxmoxClusters {
+			if pc, found := current[cluster.Name]; found && !filterChanged && reflect.DeepEqual(pc.cluster, cluster) {
+				clients = append(clients, pc)
+				continue
+			}
+
+			pc, err := NewProxmoxClient(da.etcdClient, da.views, config, cluster)
+			if err != nil {
+				restore()
+				return err
+			}
+			clients = append(clients, pc)
+			started = append(started, pc)
+		}
+	}
+
+	if err := da.etcdClient.Reconfigure(config); err != nil {
+		restore()
+		return err
+	}
+	if err := reconfigureViews(da.views, config); err != nil {
+		restore()
+		return err
+	}
+	if da.elector != nil {
+		if err := da.elector.Reconfigure(config); err != nil {
+			restore()
+			return err
+		}
+	}
+	configureLogger()
+
+	kept := make(map[*ProxmoxClient]bool)
+	for _, pc := range clients {
+		kept[pc] = true
+	}
+
+	da.mu.Lock()
+	da.config = config
+	da.proxmoxClients = clients
+	monitoring := da.proxmoxCtx != nil
+	da.mu.Unlock()
+
+	for name, pc := range current {
+		if kept[pc] {
+			continue
+		}
+		da.stopCluster(pc)
+
+		// A changed cluster republishes under the same owner
+		if findCluster(config.ProxmoxClusters, name) {
+			pc.log.Info("Proxmox cluster settings changed, reconnecting")
+			continue
+		}
+		pc.log.Info("Proxmox cluster removed from configuration")
+		if monitoring {
+			if err := da.etcdClient.RemoveStaleRecords(pc.owner, nil); err != nil {
+				pc.log.WithError(err).Error("Failed to remove DNS records of removed cluster")
+			}
+			for _, view := range da.views {
+				if err := view.EtcdClient.RemoveStaleRecords(pc.owner, nil); err != nil {
+					pc.log.WithError(err).WithField("view", view.Name).Error("Failed to remove DNS records of removed cluster")
+				}
+			}
+		}
+	}
+
+	da.mu.Lock()
+	for _, pc := range clients {
+		if _, active := da.proxmoxRuns[pc]; active {
+			pc.Resync()
+		} else {
+			da.startCluster(pc)
+		}
+	}
+	da.mu.Unlock()
+
+	if config.AgentMode == "docker" || config.AgentMode == "hybrid" {
+		da.dockerClient.Resync()
+	}
+
+	log.WithField("proxmox_clusters_restarted", len(started)).Info("Configuration reloaded")
+	return nil
+}
+
+// findCluster reports whether clusters contains a cluster called name
+func findCluster(clusters []ProxmoxClusterConfig, name string) bool {
+	for _, cluster := range clusters {
+		if cluster.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchConfigFiles calls reload whenever one of the files returned by files
+// changes, until ctx is cancelled. The files are polled, which also notices
+// the symlink swaps of Kubernetes ConfigMap and Secret volumes.
+func WatchConfigFiles(ctx context.Context, files func() []string, reload func()) {
+	versions := func() map[string]string {
+		current := make(map[string]string)
+		for _, path := range files() {
+			if info, err := os.Stat(path); err == nil {
+				current[path] = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+			}
+		}
+		return current
+	}
+
+	last := versions()
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := versions()
+			for path, version := range current {
+				if previous, found := last[path]; found && previous != version {
+					log.WithField("path", path).Info("Watched file changed")
+					reload()
+					break
+				}
+			}
+			last = current
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parseReverseZones parses the networks PTR records are published for
+func parseReverseZones(zones []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, zone := range zones {
+		_, network, err := net.ParseCIDR(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", zone, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of ip
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	const hexDigits = "0123456789abcdef"
+	nibbles := make([]string, 0, 32)
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hexDigits[ip[i]&0x0f]), string(hexDigits[ip[i]>>4]))
+	}
+	return strings.Join(nibbles, ".") + ".ip6.arpa"
+}
+
+// ptrKey returns the etcd key of the PTR record for ip pointing at hostname.
+// Each hostname gets its own sub-key so several names can share one address.
+func (ec *EtcdClient) ptrKey(ip net.IP, hostname string) string {
+	return ec.hostKey(reverseName(ip)) + "/" + recordSubKey(hostname)
+}
+
+// inReverseZones reports whether PTR records should be published for ip
+func (ec *EtcdClient) inReverseZones(ip net.IP) bool {
+	zones := ec.settings().reverseZones
+	if len(zones) == 0 {
+		return true
+	}
+	for _, zone := range zones {
+		if zone.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// createPTRRecords publishes PTR records pointing the given IPs back at hostname
+// when PTR records are enabled, and returns the keys written
+func (ec *EtcdClient) createPTRRecords(ctx context.Context, hostname string, ips []string, ttl int, owner string) ([]string, error) {
+	// Wildcard names have no meaningful reverse mapping
+	if !ec.settings().PTRRecords || strings.HasPrefix(hostname, "*.") {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, ipStr := range ips {
+		ip := net.ParseIP(ipStr)
+		if ip == nil || !ec.inReverseZones(ip) {
+			continue
+		}
+
+		key := ec.ptrKey(ip, hostname)
+		record := DNSRecord{Host: hostname, TTL: ec.recordTTL(ttl), Owner: owner}
+		if err := ec.putRecord(ctx, key, record); err != nil {
+			return keys, fmt.Errorf("failed to create PTR record for %s: %w", ipStr, err)
+		}
+		keys = append(keys, key)
+
+		log.WithFields(map[string]interface{}{
+			"hostname": hostname,
+			"ip":       ipStr,
+			"type":     "PTR",
+			"owner":    owner,
+		}).Debug("Created DNS record")
+	}
+
+	return keys, nil
+}
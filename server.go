@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serverShutdownTimeout is how long in-flight requests may take on shutdown
+const serverShutdownTimeout = 5 * time.Second
+
+// allViews returns the default view followed by the configured ones
+func (da *DNSAutomator) allViews() []View {
+	return append([]View{{Name: defaultView, EtcdClient: da.etcdClient}}, da.views...)
+}
+
+// Status returns the state of the sources, the etcd clients of every view,
+// the Proxmox API endpoints and the leader election
+func (da *DNSAutomator) Status() Status {
+	da.mu.Lock()
+	config := da.config
+	clients := da.proxmoxClients
+	da.mu.Unlock()
+
+	status := Status{
+		Version:   GetVersion(),
+		AgentMode: config.AgentMode,
+		AgentID:   config.AgentID,
+		Sources:   da.sourceStatuses(),
+	}
+
+	for _, pc := range clients {
+		status.Proxmox = append(status.Proxmox, ClusterStatus{
+			Cluster:   pc.cluster.Name,
+			Endpoints: pc.failover.Status(),
+		})
+	}
+
+	for _, view := range da.allViews() {
+		status.Providers = append(status.Providers, providerStatus(view.Name, view.EtcdClient))
+	}
+
+	if da.elector != nil {
+		isLeader, leader := da.elector.Status()
+		status.Election = &ElectionStatus{IsLeader: isLeader, Leader: leader}
+	}
+	return status
+}
+
+// sourceStatuses returns the sync state of every running source
+func (da *DNSAutomator) sourceStatuses() []SyncStatus {
+	da.mu.Lock()
+	config := da.config
+	clients := da.proxmoxClients
+	da.mu.Unlock()
+
+	sources := []SyncStatus{}
+	if config.AgentMode == "docker" || config.AgentMode == "hybrid" {
+		sources = append(sources, da.dockerClient.syncs.Status())
+	}
+	for _, pc := range clients {
+		sources = append(sources, pc.syncs.Status())
+	}
+	return sources
+}
+
+// Records returns the records published by the sources of this instance
+func (da *DNSAutomator) Records() []RecordStatus {
+	da.mu.Lock()
+	config := da.config
+	clients := da.proxmoxClients
+	da.mu.Unlock()
+
+	origins := make(map[string][]RecordOrigin)
+	add := func(sourceOrigins map[string][]RecordOrigin) {
+		for key, keyOrigins := range sourceOrigins {
+			origins[key] = append(origins[key], keyOrigins...)
+		}
+	}
+
+	if config.AgentMode == "docker" || config.AgentMode == "hybrid" {
+		add(da.dockerClient.Origins())
+	}
+	for _, pc := range clients {
+		add(pc.Origins())
+	}
+	return collectRecords(da.allViews(), origins)
+}
+
+// RunHTTPServer runs the status API on addr until ctx is cancelled
+func (da *DNSAutomator) RunHTTPServer(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, da.Status())
+	})
+	mux.HandleFunc("GET /records", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, da.Records())
+	})
+	mux.HandleFunc("GET /plan", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, da.PlannedChanges())
+	})
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		report, code := healthReport(da.Liveness())
+		writeJSON(w, code, report)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		report, code := healthReport(da.Readiness(r.Context()))
+		writeJSON(w, code, report)
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.WithField("address", addr).Info("Starting HTTP server")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// writeJSON writes value as an indented JSON response
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.WithError(err).Debug("Failed to write HTTP response")
+	}
+}
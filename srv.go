@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SRVService is a service advertised with an SRV record pointing at a published host
+type SRVService struct {
+	// Name is the service name, either "_service._proto" (placed in the
+	// domain of the host) or a fully qualified "_service._proto.domain"
+	Name     string
+	Port     int
+	Priority int
+	Weight   int
+}
+
+// parseSRVSpec parses "_service._proto[.domain]:port[:priority[:weight]]"
+func parseSRVSpec(spec string) (SRVService, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return SRVService{}, fmt.Errorf("invalid SRV spec %q: expected _service._proto:port[:priority[:weight]]", spec)
+	}
+
+	service := SRVService{Name: strings.Trim(parts[0], ".")}
+	labels := strings.Split(service.Name, ".")
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return SRVService{}, fmt.Errorf("invalid SRV service name %q: expected _service._proto", parts[0])
+	}
+
+	numbers := make([]int, 3)
+	for i, part := range parts[1:] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 65535 {
+			return SRVService{}, fmt.Errorf("invalid SRV spec %q: %q is not a valid number", spec, part)
+		}
+		numbers[i] = n
+	}
+	if numbers[0] == 0 {
+		return SRVService{}, fmt.Errorf("invalid SRV spec %q: port is required", spec)
+	}
+	service.Port, service.Priority, service.Weight = numbers[0], numbers[1], numbers[2]
+
+	return service, nil
+}
+
+// parseSRVSpecs parses a list of SRV specs
+func parseSRVSpecs(specs []string) ([]SRVService, error) {
+	var services []SRVService
+	for _, spec := range specs {
+		service, err := parseSRVSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// srvName returns the owner name of the SRV record for service on hostname
+func srvName(service SRVService, hostname string) string {
+	if strings.Count(service.Name, ".") > 1 {
+		return service.Name
+	}
+
+	// Short names live in the parent domain of the host
+	domain := hostname
+	if i := strings.Index(hostname, "."); i >= 0 {
+		domain = hostname[i+1:]
+	}
+	return service.Name + "." + domain
+}
+
+// CreateSRVRecords advertises services pointing at hostname and returns the
+// keys written. Each target host and owner gets its own sub-key so several
+// hosts, or several agents serving the same host, can provide the same
+// service. Services refused by CONFLICT_POLICY do not stop the others; they
+// are returned as errConflictRefused.
+func (ec *EtcdClient) CreateSRVRecords(hostname string, services []SRVService, ttl int, owner string) ([]string, error) {
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var keys []string
+	var refused []error
+	for _, service := range services {
+		name := srvName(service, hostname)
+		key := ec.hostKey(name) + "/" + recordSubKey(hostname) + "-" + ownerSubKey(owner)
+
+		record := DNSRecord{
+			Host:     hostname,
+			Port:     service.Port,
+			Priority: service.Priority,
+			Weight:   service.Weight,
+			TTL:      ec.recordTTL(ttl),
+			Owner:    owner,
+		}
+		err := ec.putOwnedRecord(ctx, name, key, record)
+		if errors.Is(err, errConflictRefused) {
+			refused = append(refused, fmt.Errorf("SRV record %s for %s: %w", name, hostname, err))
+			continue
+		} else if err != nil {
+			return keys, fmt.Errorf("failed to create SRV record %s for %s: %w", name, hostname, err)
+		}
+		keys = append(keys, key)
+
+		log.WithFields(map[string]interface{}{
+			"name":   name,
+			"target": hostname,
+			"port":   service.Port,
+			"type":   "SRV",
+			"owner":  owner,
+		}).Info("Created DNS record")
+	}
+
+	return keys, errors.Join(refused...)
+}
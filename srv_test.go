@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseSRVSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    SRVService
+		wantErr bool
+	}{
+		{spec: "_http._tcp:80", want: SRVService{Name: "_http._tcp", Port: 80}},
+		{spec: " _sip._udp:5060:10:20 ", want: SRVService{Name: "_sip._udp", Port: 5060, Priority: 10, Weight: 20}},
+		{spec: "_ldap._tcp.example.com.:389:5", want: SRVService{Name: "_ldap._tcp.example.com", Port: 389, Priority: 5}},
+		{spec: "_http._tcp", wantErr: true},
+		{spec: "http._tcp:80", wantErr: true},
+		{spec: "_http:80", wantErr: true},
+		{spec: "_http._tcp:0", wantErr: true},
+		{spec: "_http._tcp:70000", wantErr: true},
+		{spec: "_http._tcp:80:x", wantErr: true},
+		{spec: "_http._tcp:80:1:2:3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseSRVSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSRVSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseSRVSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errIncompleteSync is returned by a sync that could not read every container
+// or guest. Records were still published, but stale ones were kept, so the
+// sync counts as successful with a warning.
+var errIncompleteSync = errors.New("sync incomplete, skipped stale record cleanup")
+
+// errSyncConflicts is returned by a sync that left names to other owners as
+// CONFLICT_POLICY demands. Everything else was published and cleaned up, so
+// the sync counts as successful with a warning.
+var errSyncConflicts = fmt.Errorf("names held by another owner were not published: %w", errConflictRefused)
+
+// errInvalidGuestSettings is returned by a sync that kept the records of
+// guests whose DNS settings are invalid from the previous sync. Everything
+// else was published and cleaned up, so the sync counts as successful with a
+// warning.
+var errInvalidGuestSettings = errors.New("guests with invalid DNS settings kept their previous records")
+
+// RecordOrigin identifies the container or guest a record was published for
+type RecordOrigin struct {
+	Source        string `json:"source"`
+	ContainerID   string `json:"container_id,omitempty"`
+	ContainerName string `json:"container_name,omitempty"`
+	Cluster       string `json:"cluster,omitempty"`
+	Node          string `json:"node,omitempty"`
+	VMID          uint64 `json:"vmid,omitempty"`
+	Guest         string `json:"guest,omitempty"`
+}
+
+// RecordStatus is a record DNSherpa currently publishes
+type RecordStatus struct {
+	Key     string          `json:"key"`
+	View    string          `json:"view"`
+	Record  json.RawMessage `json:"record"`
+	Origins []RecordOrigin  `json:"origins"`
+}
+
+// SyncStatus is the outcome of the latest syncs of a source. Docker keeps its
+// records current from events between syncs, so it also reports LastEvent.
+type SyncStatus struct {
+	Source      string     `json:"source"`
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastEvent   *time.Time `json:"last_event,omitempty"`
+	LastWarning string     `json:"last_warning,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// ProviderStatus is the state of the etcd client publishing one view
+type ProviderStatus struct {
+	View           string         `json:"view"`
+	Prefix         string         `json:"prefix"`
+	Endpoints      []string       `json:"endpoints"`
+	ManagedRecords int            `json:"managed_records"`
+	Conflicts      map[string]int `json:"conflicts"`
+	Tampering      map[string]int `json:"tampering"`
+}
+
+// ClusterStatus is the state of the API endpoints of a Proxmox cluster
+type ClusterStatus struct {
+	Cluster   string           `json:"cluster"`
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// ElectionStatus is the state of the leader election
+type ElectionStatus struct {
+	IsLeader bool   `json:"is_leader"`
+	Leader   string `json:"leader"`
+}
+
+// Status is the state of this DNSherpa instance
+type Status struct {
+	Version   string           `json:"version"`
+	AgentMode string           `json:"agent_mode"`
+	AgentID   string           `json:"agent_id"`
+	Sources   []SyncStatus     `json:"sources"`
+	Providers []ProviderStatus `json:"providers"`
+	Proxmox   []ClusterStatus  `json:"proxmox,omitempty"`
+	Election  *ElectionStatus  `json:"election,omitempty"`
+}
+
+// syncTracker records the outcome of the syncs of a source
+type syncTracker struct {
+	mu     sync.Mutex
+	status SyncStatus
+}
+
+// record stores the outcome of a sync that ended now. An incomplete sync, one
+// that left names to other owners or one that skipped guests with invalid
+// settings reached the API and published what it could, so it counts as
+// successful but keeps its error as a warning.
+func (t *syncTracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.status.LastAttempt = &now
+	if err != nil && !errors.Is(err, errIncompleteSync) && !errors.Is(err, errConflictRefused) && !errors.Is(err, errInvalidGuestSettings) {
+		t.status.LastError = err.Error()
+		t.status.LastErrorAt = &now
+		syncErrors.WithLabelValues(t.status.Source).Inc()
+		return
+	}
+
+	t.status.LastWarning = ""
+	if err != nil {
+		t.status.LastWarning = err.Error()
+		syncErrors.WithLabelValues(t.status.Source).Inc()
+	}
+	t.status.LastSync = &now
+	t.status.LastError = ""
+	lastSuccessfulSync.WithLabelValues(t.status.Source).Set(float64(now.Unix()))
+}
+
+// recordEvent stores that an event changed records and was handled without
+// errors. The event brought the records up to date, so it also counts as a
+// successful sync for the staleness metric.
+func (t *syncTracker) recordEvent() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.status.LastEvent = &now
+	lastSuccessfulSync.WithLabelValues(t.status.Source).Set(float64(now.Unix()))
+}
+
+// Status returns a copy of the sync state
+func (t *syncTracker) Status() SyncStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
+
+// providerStatus returns the state of the client publishing view
+func providerStatus(view string, ec *EtcdClient) ProviderStatus {
+	settings := ec.settings()
+
+	ec.managedMu.Lock()
+	managed := len(ec.managed)
+	ec.managedMu.Unlock()
+
+	return ProviderStatus{
+		View:           view,
+		Prefix:         settings.EtcdPrefix,
+		Endpoints:      settings.EtcdEndpoints,
+		ManagedRecords: managed,
+		Conflicts:      ec.ConflictCounts(),
+		Tampering:      ec.TamperingCounts(),
+	}
+}
+
+// collectRecords joins the origins reported by the sources with the values
+// last written by the view clients, sorted by key. Keys are matched to views
+// by prefix, which never overlap.
+func collectRecords(views []View, origins map[string][]RecordOrigin) []RecordStatus {
+	records := make([]RecordStatus, 0, len(origins))
+	for key, keyOrigins := range origins {
+		for _, view := range views {
+			if !strings.HasPrefix(key, view.EtcdClient.settings().EtcdPrefix+"/") {
+				continue
+			}
+			value, found := view.EtcdClient.managedValue(key)
+			if !found {
+				break
+			}
+			records = append(records, RecordStatus{
+				Key:     key,
+				View:    view.Name,
+				Record:  json.RawMessage(value),
+				Origins: keyOrigins,
+			})
+			break
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	return records
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSyncTrackerRecord(t *testing.T) {
+	tracker := syncTracker{status: SyncStatus{Source: "test"}}
+
+	tracker.record(errors.New("connection refused"))
+	status := tracker.Status()
+	if status.LastSync != nil || status.LastError != "connection refused" {
+		t.Fatalf("after a failed sync: %+v", status)
+	}
+
+	tracker.record(fmt.Errorf("cluster lab: %w", errIncompleteSync))
+	status = tracker.Status()
+	if status.LastSync == nil || status.LastError != "" || status.LastWarning == "" {
+		t.Fatalf("after an incomplete sync: %+v", status)
+	}
+
+	tracker.record(errSyncConflicts)
+	status = tracker.Status()
+	if status.LastError != "" || status.LastWarning != errSyncConflicts.Error() {
+		t.Fatalf("after a sync with refused names: %+v", status)
+	}
+
+	tracker.record(errors.Join(errSyncConflicts, errInvalidGuestSettings))
+	status = tracker.Status()
+	if status.LastError != "" || status.LastWarning == "" {
+		t.Fatalf("after a sync that kept guests with invalid settings: %+v", status)
+	}
+
+	tracker.record(nil)
+	status = tracker.Status()
+	if status.LastSync == nil || status.LastWarning != "" {
+		t.Fatalf("after a successful sync: %+v", status)
+	}
+
+	tracker.recordEvent()
+	if tracker.Status().LastEvent == nil {
+		t.Fatal("recordEvent did not set LastEvent")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTargetResolvers is the resolver chain used when DNS_TARGET_RESOLVERS
+// is not set. os-hostname and default-route are left out because inside a
+// bridged container they return the container's own name and address.
+var defaultTargetResolvers = []string{"env", "hostname-file", "docker"}
+
+// hostnameFile is where the host's /etc/hostname is expected to be mounted
+const hostnameFile = "/host/hostname"
+
+// TargetResolver finds the name or address the Docker records of this host
+// should point at
+type TargetResolver interface {
+	// Name identifies the resolver in DNS_TARGET_RESOLVERS and the logs
+	Name() string
+
+	// Resolve returns the target, or an error if this resolver cannot tell
+	Resolve(ctx context.Context) (string, error)
+}
+
+// targetResolvers maps the names usable in DNS_TARGET_RESOLVERS to resolvers
+func targetResolvers(config Config, dockerClient *DockerClient) map[string]TargetResolver {
+	return map[string]TargetResolver{
+		"env":           envTargetResolver{target: config.DNSTarget},
+		"hostname-file": hostnameFileResolver{path: hostnameFile, domain: config.Domain},
+		"os-hostname":   osHostnameResolver{domain: config.Domain},
+		"default-route": defaultRouteResolver{},
+		"docker":        dockerNameResolver{client: dockerClient, domain: config.Domain},
+	}
+}
+
+// validateTargetResolvers checks DNS_TARGET_RESOLVERS
+func validateTargetResolvers(names []string) error {
+	known := targetResolvers(Config{}, nil)
+	for _, name := range names {
+		if _, found := known[name]; !found {
+			return fmt.Errorf("unknown resolver %q (valid options: env, hostname-file, os-hostname, default-route, docker)", name)
+		}
+	}
+	return nil
+}
+
+// ResolveDNSTarget runs the resolver chain and returns the first target found.
+// If no resolver finds one, the error lists why each of them failed.
+func ResolveDNSTarget(ctx context.Context, config Config, dockerClient *DockerClient) (string, error) {
+	names := config.DNSTargetResolvers
+	if len(names) == 0 {
+		names = defaultTargetResolvers
+	}
+
+	resolvers := targetResolvers(config, dockerClient)
+	var errs []error
+	for _, name := range names {
+		resolver, found := resolvers[name]
+		if !found {
+			return "", fmt.Errorf("unknown DNS target resolver %q", name)
+		}
+
+		target, err := resolver.Resolve(ctx)
+		if err != nil {
+			log.WithFields(map[string]interface{}{
+				"resolver": resolver.Name(),
+				"error":    err,
+			}).Debug("DNS target resolver found nothing")
+			errs = append(errs, fmt.Errorf("%s: %w", resolver.Name(), err))
+			continue
+		}
+
+		log.WithFields(map[string]interface{}{
+			"resolver": resolver.Name(),
+			"target":   target,
+		}).Info("Detected DNS target")
+		return target, nil
+	}
+
+	return "", fmt.Errorf("could not determine the DNS target, set DNS_TARGET: %w", errors.Join(errs...))
+}
+
+// domainTarget is the DNS target for Docker hosts in a domain and below it
+type domainTarget struct {
+	domain string
+	target string
+}
+
+// parseDomainTargets parses "domain=target" entries, most specific domain first
+func parseDomainTargets(entries []string) ([]domainTarget, error) {
+	var targets []domainTarget
+	for _, entry := range entries {
+		domain, target, found := strings.Cut(entry, "=")
+		domain = strings.Trim(strings.TrimSpace(domain), ".")
+		target = strings.TrimSpace(target)
+		if !found || domain == "" || target == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected domain=target", entry)
+		}
+		targets = append(targets, domainTarget{domain: domain, target: target})
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool {
+		return len(targets[i].domain) > len(targets[j].domain)
+	})
+	return targets, nil
+}
+
+// parseEntrypointTargets parses "entrypoint=target" entries
+func parseEntrypointTargets(entries []string) (map[string]string, error) {
+	targets := make(map[string]string)
+	for _, entry := range entries {
+		entrypoint, target, found := strings.Cut(entry, "=")
+		entrypoint = strings.TrimSpace(entrypoint)
+		target = strings.TrimSpace(target)
+		if !found || entrypoint == "" || target == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected entrypoint=target", entry)
+		}
+		targets[entrypoint] = target
+	}
+	return targets, nil
+}
+
+// resolveIdentity fills in the DNS target, which only the Docker source needs,
+// and the agent ID, which defaults to the target or else the OS hostname
+func resolveIdentity(config Config, dockerClient *DockerClient) (Config, error) {
+	if config.AgentMode == "docker" || config.AgentMode == "hybrid" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		target, err := ResolveDNSTarget(ctx, config, dockerClient)
+		if err != nil {
+			return config, err
+		}
+		config.DNSTarget = target
+	}
+
+	if config.AgentID == "" {
+		config.AgentID = config.DNSTarget
+	}
+	if config.AgentID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return config, fmt.Errorf("failed to determine AGENT_ID: %w", err)
+		}
+		config.AgentID = hostname
+	}
+	return config, nil
+}
+
+// qualifyHostname appends domain to a hostname that is not fully qualified
+func qualifyHostname(hostname, domain string) (string, error) {
+	hostname = strings.TrimSuffix(strings.TrimSpace(hostname), ".")
+	if hostname == "" {
+		return "", errors.New("hostname is empty")
+	}
+	if strings.Contains(hostname, ".") {
+		return hostname, nil
+	}
+	if domain == "" {
+		return "", fmt.Errorf("hostname %q is not fully qualified and DOMAIN is not set", hostname)
+	}
+	return hostname + "." + domain, nil
+}
+
+// envTargetResolver returns DNS_TARGET as configured
+type envTargetResolver struct {
+	target string
+}
+
+func (r envTargetResolver) Name() string { return "env" }
+
+func (r envTargetResolver) Resolve(ctx context.Context) (string, error) {
+	if r.target == "" {
+		return "", errors.New("DNS_TARGET is not set")
+	}
+	return r.target, nil
+}
+
+// hostnameFileResolver reads the host's hostname from a mounted /etc/hostname
+type hostnameFileResolver struct {
+	path   string
+	domain string
+}
+
+func (r hostnameFileResolver) Name() string { return "hostname-file" }
+
+func (r hostnameFileResolver) Resolve(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return "", fmt.Errorf("%w (mount the host's /etc/hostname with -v /etc/hostname:%s:ro)", err, r.path)
+	}
+	return qualifyHostname(string(data), r.domain)
+}
+
+// osHostnameResolver uses the hostname of this process, which is the host's
+// name only with host networking
+type osHostnameResolver struct {
+	domain string
+}
+
+func (r osHostnameResolver) Name() string { return "os-hostname" }
+
+func (r osHostnameResolver) Resolve(ctx context.Context) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return qualifyHostname(hostname, r.domain)
+}
+
+// defaultRouteResolver uses the first address of the interface holding the
+// default IPv4 route
+type defaultRouteResolver struct{}
+
+func (r defaultRouteResolver) Name() string { return "default-route" }
+
+func (r defaultRouteResolver) Resolve(ctx context.Context) (string, error) {
+	name, err := defaultRouteInterface("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
+// defaultRouteInterface returns the interface of the default route listed in
+// a /proc/net/route table
+func defaultRouteInterface(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no default route")
+}
+
+// dockerNameResolver asks the Docker daemon for the name of its host
+type dockerNameResolver struct {
+	client *DockerClient
+	domain string
+}
+
+func (r dockerNameResolver) Name() string { return "docker" }
+
+func (r dockerNameResolver) Resolve(ctx context.Context) (string, error) {
+	if r.client == nil {
+		return "", errors.New("Docker client not available")
+	}
+	info, err := r.client.client.Info(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Docker daemon info: %w", err)
+	}
+	return qualifyHostname(info.Name, r.domain)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestDockerTarget(t *testing.T) {
+	settings, err := newEtcdSettings(Config{
+		DNSTarget:         "traefik.example.com",
+		DomainTargets:     []string{"example.com=edge.example.com", "internal.example.com=10.0.0.1"},
+		EntrypointTargets: []string{"websecure-lan=lan.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("newEtcdSettings() error = %v", err)
+	}
+	ec := &EtcdClient{current: settings}
+
+	tests := []struct {
+		name        string
+		hostname    string
+		entrypoints []string
+		want        string
+	}{
+		{name: "default", hostname: "app.example.org", want: "traefik.example.com"},
+		{name: "domain", hostname: "app.example.com", want: "edge.example.com"},
+		{name: "domain apex", hostname: "example.com", want: "edge.example.com"},
+		{name: "most specific domain", hostname: "db.internal.example.com", want: "10.0.0.1"},
+		{name: "suffix is not a subdomain", hostname: "app.notexample.com", want: "traefik.example.com"},
+		{name: "entrypoint wins", hostname: "db.internal.example.com", entrypoints: []string{"web", "websecure-lan"}, want: "lan.example.com"},
+		{name: "unknown entrypoint", hostname: "app.example.com", entrypoints: []string{"web"}, want: "edge.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ec.DockerTarget(tt.hostname, tt.entrypoints); got != tt.want {
+				t.Errorf("DockerTarget(%q, %q) = %q, want %q", tt.hostname, tt.entrypoints, got, tt.want)
+			}
+		})
+	}
+}
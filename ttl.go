@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// domainTTL is a TTL override for a domain and all names below it
+type domainTTL struct {
+	domain string
+	ttl    int
+}
+
+// parseDomainTTLs parses "domain=ttl" entries, most specific domain first
+func parseDomainTTLs(entries []string) ([]domainTTL, error) {
+	var ttls []domainTTL
+	for _, entry := range entries {
+		domain, value, found := strings.Cut(entry, "=")
+		domain = strings.Trim(strings.TrimSpace(domain), ".")
+		if !found || domain == "" {
+			return nil, fmt.Errorf("invalid entry %q: expected domain=ttl", entry)
+		}
+		ttl, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid TTL in %q: must be a positive number of seconds", entry)
+		}
+		ttls = append(ttls, domainTTL{domain: domain, ttl: ttl})
+	}
+
+	sort.SliceStable(ttls, func(i, j int) bool {
+		return len(ttls[i].domain) > len(ttls[j].domain)
+	})
+	return ttls, nil
+}
+
+// ResolveTTL picks the TTL of a record for hostname: the label/tag ttl if set,
+// then the most specific DOMAIN_TTLS entry, then the source TTL, then RECORD_TTL
+func (ec *EtcdClient) ResolveTTL(hostname string, sourceTTL, ttl int) int {
+	if ttl > 0 {
+		return ttl
+	}
+
+	for _, override := range ec.settings().domainTTLs {
+		if hostname == override.domain || strings.HasSuffix(hostname, "."+override.domain) {
+			return override.ttl
+		}
+	}
+
+	return ec.recordTTL(sourceTTL)
+}
+
+// recordState tracks the content last written to a key and since when it is unchanged
+type recordState struct {
+	record DNSRecord // with the full TTL
+	since  time.Time
+	warm   bool // last written with the warming TTL
+}
+
+// putRecord writes record to key. With WARMING_TTL set, new or changed records
+// are published with the short warming TTL until they have been stable for
+// WARMING_PERIOD.
+func (ec *EtcdClient) putRecord(ctx context.Context, key string, record DNSRecord) error {
+	value, err := ec.recordValue(ctx, key, record)
+	if err != nil {
+		return err
+	}
+
+	undo := ec.remember(key, value)
+	if ec.plan != nil {
+		if err := ec.planPut(ctx, key, value); err != nil {
+			undo()
+			return err
+		}
+		return nil
+	}
+	done := etcdTimer("put")
+	_, err = ec.etcd().Put(ctx, key, value)
+	done()
+	if err != nil {
+		undo()
+		recordFailures.WithLabelValues(ec.settings().EtcdPrefix, "write").Inc()
+		return err
+	}
+	recordWrites.WithLabelValues(ec.settings().EtcdPrefix).Inc()
+	return nil
+}
+
+// recordValue returns the value to store for record at key, applying the warming TTL
+func (ec *EtcdClient) recordValue(ctx context.Context, key string, record DNSRecord) (string, error) {
+	if warmingTTL := ec.settings().WarmingTTL; warmingTTL > 0 && warmingTTL < record.TTL {
+		record.TTL = ec.warmingTTL(ctx, key, record)
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal record: %w", err)
+	}
+	return string(recordJSON), nil
+}
+
+// warmingTTL returns the TTL to write record with, updating its stability state
+func (ec *EtcdClient) warmingTTL(ctx context.Context, key string, record DNSRecord) int {
+	ec.warmingMu.Lock()
+	_, tracked := ec.warming[key]
+	ec.warmingMu.Unlock()
+
+	// Read etcd without holding the lock, only for keys not tracked yet
+	published := !tracked && ec.isPublished(ctx, key, record)
+
+	ec.warmingMu.Lock()
+	defer ec.warmingMu.Unlock()
+
+	state, found := ec.warming[key]
+	if !found || state.record != record {
+		state = &recordState{record: record, since: time.Now()}
+		if !found && published {
+			// Published unchanged by a previous run, nothing to warm up
+			state.since = time.Time{}
+		}
+		ec.warming[key] = state
+	}
+
+	settings := ec.settings()
+	state.warm = time.Since(state.since) < settings.WarmingPeriod
+	if state.warm {
+		return settings.WarmingTTL
+	}
+	return record.TTL
+}
+
+// isPublished reports whether key already holds record, ignoring the TTL
+func (ec *EtcdClient) isPublished(ctx context.Context, key string, record DNSRecord) bool {
+	done := etcdTimer("get")
+	resp, err := ec.etcd().Get(ctx, key)
+	done()
+	if err != nil || len(resp.Kvs) == 0 {
+		return false
+	}
+
+	var current DNSRecord
+	if err := json.Unmarshal(resp.Kvs[0].Value, &current); err != nil {
+		return false
+	}
+	return sameRecord(current, record)
+}
+
+// sameRecord compares two records ignoring their TTL
+func sameRecord(a, b DNSRecord) bool {
+	a.TTL, b.TTL = 0, 0
+	return a == b
+}
+
+// forgetRecords drops the warming and watch state of keys about to be deleted
+func (ec *EtcdClient) forgetRecords(keys []string) {
+	ec.warmingMu.Lock()
+	for _, key := range keys {
+		delete(ec.warming, key)
+	}
+	ec.warmingMu.Unlock()
+
+	ec.managedMu.Lock()
+	for _, key := range keys {
+		delete(ec.managed, key)
+	}
+	ec.managedMu.Unlock()
+}
+
+// forgetRecord drops the warming and watch state of a key about to be deleted
+// and returns a function that restores it if the delete does not happen
+func (ec *EtcdClient) forgetRecord(key string) (undo func()) {
+	ec.warmingMu.Lock()
+	state, warming := ec.warming[key]
+	delete(ec.warming, key)
+	ec.warmingMu.Unlock()
+
+	ec.managedMu.Lock()
+	value, managed := ec.managed[key]
+	delete(ec.managed, key)
+	ec.managedMu.Unlock()
+
+	return func() {
+		if warming {
+			ec.warmingMu.Lock()
+			ec.warming[key] = state
+			ec.warmingMu.Unlock()
+		}
+		if managed {
+			ec.managedMu.Lock()
+			ec.managed[key] = value
+			ec.managedMu.Unlock()
+		}
+	}
+}
+
+// PromoteWarmRecords raises records that have been stable for WARMING_PERIOD
+// from the warming TTL to their full TTL. Sources that rewrite their records on
+// every sync get this anyway; event driven sources such as Docker rely on it.
+func (ec *EtcdClient) PromoteWarmRecords(ctx context.Context) error {
+	due := make(map[string]DNSRecord)
+	period := ec.settings().WarmingPeriod
+
+	ec.warmingMu.Lock()
+	for key, state := range ec.warming {
+		if state.warm && time.Since(state.since) >= period {
+			due[key] = state.record
+			state.warm = false
+		}
+	}
+	ec.warmingMu.Unlock()
+
+	for key, record := range due {
+		// Leave records alone that were changed or removed in the meantime
+		if !ec.isPublished(ctx, key, record) {
+			continue
+		}
+
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record: %w", err)
+		}
+		undo := ec.remember(key, string(recordJSON))
+		done := etcdTimer("put")
+		_, err = ec.etcd().Put(ctx, key, string(recordJSON))
+		done()
+		if err != nil {
+			undo()
+			recordFailures.WithLabelValues(ec.settings().EtcdPrefix, "write").Inc()
+			return fmt.Errorf("failed to raise TTL of %s: %w", key, err)
+		}
+		recordWrites.WithLabelValues(ec.settings().EtcdPrefix).Inc()
+
+		log.WithFields(map[string]interface{}{
+			"key": key,
+			"ttl": record.TTL,
+		}).Debug("Raised TTL of stable DNS record")
+	}
+
+	return nil
+}
+
+// RunWarming periodically promotes stable records until ctx is cancelled
+func (ec *EtcdClient) RunWarming(ctx context.Context) {
+	interval := ec.settings().WarmingPeriod
+	if interval <= 0 {
+		return
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			promoteCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			if err := ec.PromoteWarmRecords(promoteCtx); err != nil {
+				log.WithError(err).Error("Failed to raise TTL of warm DNS records")
+			}
+			cancel()
+		}
+	}
+}
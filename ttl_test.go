@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDomainTTLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []domainTTL
+		wantErr bool
+	}{
+		{name: "empty", entries: nil, want: nil},
+		{
+			name:    "most specific first",
+			entries: []string{"example.com=300", " .lab.example.com. = 30 "},
+			want:    []domainTTL{{"lab.example.com", 30}, {"example.com", 300}},
+		},
+		{name: "missing ttl", entries: []string{"example.com"}, wantErr: true},
+		{name: "missing domain", entries: []string{"=300"}, wantErr: true},
+		{name: "zero ttl", entries: []string{"example.com=0"}, wantErr: true},
+		{name: "not a number", entries: []string{"example.com=5m"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDomainTTLs(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDomainTTLs(%q) error = %v, wantErr %v", tt.entries, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDomainTTLs(%q) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
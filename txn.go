@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// maxTxnAttempts is how often a name is re-read and written again when it
+// was changed concurrently
+const maxTxnAttempts = 3
+
+// recordIndexKey matches the numbered sub-keys of A/AAAA record sets
+var recordIndexKey = regexp.MustCompile(`^(a|aaaa)[0-9]+$`)
+
+// storedRecord is a key at a name together with the revision it was read at
+type storedRecord struct {
+	key         string
+	modRevision int64
+	record      DNSRecord
+	valid       bool // value is a DNS record
+	leaf        bool // stored on the name itself rather than a sub-key
+}
+
+// readName returns the records stored on hostname itself and on its direct
+// sub-keys. Deeper keys belong to other names and the wildcard is separate.
+func (ec *EtcdClient) readName(ctx context.Context, hostname string) ([]storedRecord, error) {
+	stored, err := ec.readNameKey(ctx, ec.hostKey(hostname))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", hostname, err)
+	}
+	return stored, nil
+}
+
+// readNameKey returns the records stored on the name key base and its direct sub-keys
+func (ec *EtcdClient) readNameKey(ctx context.Context, base string) ([]storedRecord, error) {
+	done := etcdTimer("get")
+	leaf, err := ec.etcd().Get(ctx, base)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	done = etcdTimer("get")
+	children, err := ec.etcd().Get(ctx, base+"/", clientv3.WithPrefix())
+	done()
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []storedRecord
+	for _, kv := range append(leaf.Kvs, children.Kvs...) {
+		key := string(kv.Key)
+		isLeaf := key == base
+		if !isLeaf {
+			child := strings.TrimPrefix(key, base+"/")
+			if strings.Contains(child, "/") || child == "*" {
+				continue
+			}
+		}
+
+		entry := storedRecord{key: key, modRevision: kv.ModRevision, leaf: isLeaf}
+		entry.valid = json.Unmarshal(kv.Value, &entry.record) == nil
+		stored = append(stored, entry)
+	}
+
+	return stored, nil
+}
+
+// nameKeyOf returns the name key that writeName stored key under: numbered
+// address records and Docker records are sub-keys of their name, other
+// records such as Proxmox CNAMEs are stored on the name itself
+func (ec *EtcdClient) nameKeyOf(key string) string {
+	i := strings.LastIndex(key, "/")
+	if child := key[i+1:]; recordIndexKey.MatchString(child) || child == ec.agentSubKey() {
+		return key[:i]
+	}
+	return key
+}
+
+// staleRecordKeys returns the address records owner still has at the name in
+// another layout or with a higher index than it is about to write, together
+// with the records of older versions it adopts there
+func staleRecordKeys(stored []storedRecord, keys []string, owner string) []string {
+	writing := make(map[string]bool)
+	for _, key := range keys {
+		writing[key] = true
+	}
+
+	var stale []string
+	for _, entry := range stored {
+		if !entry.valid || addressType(entry.record) == "" || writing[entry.key] {
+			continue
+		}
+		if entry.record.Owner != owner {
+			if adoptsLegacy(entry, writing, owner) {
+				stale = append(stale, entry.key)
+			}
+			continue
+		}
+		if entry.leaf || recordIndexKey.MatchString(entry.key[strings.LastIndex(entry.key, "/")+1:]) {
+			stale = append(stale, entry.key)
+		}
+	}
+	return stale
+}
+
+// putOwnedRecord writes record to key, which only this owner writes to, in a
+// transaction that applies only if the key did not change since it was read.
+// A record of another owner at the key is a conflict that only
+// CONFLICT_POLICY=takeover overwrites; a record without an owner is adopted.
+func (ec *EtcdClient) putOwnedRecord(ctx context.Context, hostname, key string, record DNSRecord) error {
+	for attempt := 1; ; attempt++ {
+		done := etcdTimer("get")
+		resp, err := ec.etcd().Get(ctx, key)
+		done()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+
+		cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		if len(resp.Kvs) > 0 {
+			var existing DNSRecord
+			if json.Unmarshal(resp.Kvs[0].Value, &existing) == nil && existing.Owner != "" && existing.Owner != record.Owner {
+				policy := ec.settings().ConflictPolicy
+				action := "refused"
+				if policy == ConflictTakeover {
+					action = "took over"
+				}
+				ec.countConflict("owner", action)
+				log.WithFields(map[string]interface{}{
+					"hostname":       hostname,
+					"key":            key,
+					"owner":          record.Owner,
+					"existing_owner": existing.Owner,
+					"conflict":       "owner",
+					"policy":         policy,
+					"action":         action,
+				}).Warn("DNS record conflict")
+				if policy != ConflictTakeover {
+					return errConflictRefused
+				}
+			}
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+		}
+
+		value, err := ec.recordValue(ctx, key, record)
+		if err != nil {
+			return err
+		}
+		undo := ec.remember(key, value)
+		if ec.plan != nil {
+			if err := ec.planPut(ctx, key, value); err != nil {
+				undo()
+				return err
+			}
+			return nil
+		}
+
+		done = etcdTimer("txn")
+		txn, err := ec.etcd().Txn(ctx).If(cmp).Then(clientv3.OpPut(key, value)).Commit()
+		done()
+		if err != nil || !txn.Succeeded {
+			undo()
+		}
+		if err != nil {
+			recordFailures.WithLabelValues(ec.settings().EtcdPrefix, "write").Inc()
+			return err
+		}
+		if txn.Succeeded {
+			recordWrites.WithLabelValues(ec.settings().EtcdPrefix).Inc()
+			return nil
+		}
+
+		if attempt >= maxTxnAttempts {
+			return fmt.Errorf("changed concurrently %d times", attempt)
+		}
+	}
+}
+
+// writeName replaces the address records of hostname with records at keys in
+// a single transaction: conflicting records are resolved according to
+// CONFLICT_POLICY and stale indices of the same owner are deleted. The
+// transaction only applies if nothing at the name changed since it was read,
+// otherwise it is retried. It reports whether the records were written.
+func (ec *EtcdClient) writeName(ctx context.Context, hostname string, keys []string, records []DNSRecord, owner string) (bool, error) {
+	types := make([]string, len(records))
+	for i, record := range records {
+		types[i] = addressType(record)
+	}
+
+	for attempt := 1; ; attempt++ {
+		stored, err := ec.readName(ctx, hostname)
+		if err != nil {
+			return false, err
+		}
+
+		proceed, takeover := ec.resolveConflicts(hostname, stored, keys, types, owner)
+		if !proceed {
+			return false, nil
+		}
+		deletes := append(takeover, staleRecordKeys(stored, keys, owner)...)
+
+		// Everything read must be unchanged and new keys must still be absent
+		var cmps []clientv3.Cmp
+		existing := make(map[string]bool)
+		for _, entry := range stored {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(entry.key), "=", entry.modRevision))
+			existing[entry.key] = true
+		}
+		for _, key := range keys {
+			if !existing[key] {
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+			}
+		}
+
+		var ops []clientv3.Op
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i], err = ec.recordValue(ctx, key, records[i])
+			if err != nil {
+				return false, err
+			}
+			ops = append(ops, clientv3.OpPut(key, values[i]))
+		}
+		for _, key := range deletes {
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+
+		// A dry run plans the transaction instead of committing it
+		if ec.plan != nil {
+			ec.forgetRecords(deletes)
+			for i, key := range keys {
+				ec.remember(key, values[i])
+			}
+			return true, ec.planWrites(ctx, keys, values, deletes)
+		}
+
+		// Update the watch state first so it never restores deleted keys or
+		// mistakes our own writes for tampering, and put it back if the
+		// transaction does not apply
+		var undo []func()
+		for _, key := range deletes {
+			undo = append(undo, ec.forgetRecord(key))
+		}
+		for i, key := range keys {
+			undo = append(undo, ec.remember(key, values[i]))
+		}
+
+		done := etcdTimer("txn")
+		resp, err := ec.etcd().Txn(ctx).If(cmps...).Then(ops...).Commit()
+		done()
+		if err != nil || !resp.Succeeded {
+			for _, restore := range undo {
+				restore()
+			}
+		}
+		if err != nil {
+			recordFailures.WithLabelValues(ec.settings().EtcdPrefix, "write").Inc()
+			return false, fmt.Errorf("failed to write %s: %w", hostname, err)
+		}
+		if resp.Succeeded {
+			recordWrites.WithLabelValues(ec.settings().EtcdPrefix).Add(float64(len(keys)))
+			recordDeletes.WithLabelValues(ec.settings().EtcdPrefix).Add(float64(len(deletes)))
+			for _, key := range deletes {
+				log.WithField("key", key).Info("Deleted DNS record")
+			}
+			return true, nil
+		}
+
+		if attempt >= maxTxnAttempts {
+			return false, fmt.Errorf("failed to write %s: changed concurrently %d times", hostname, attempt)
+		}
+		log.WithFields(map[string]interface{}{
+			"hostname": hostname,
+			"attempt":  attempt,
+		}).Debug("DNS records changed concurrently, retrying")
+	}
+}
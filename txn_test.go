@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaleRecordKeys(t *testing.T) {
+	const name = "/skydns/com/example/web"
+	stored := []storedRecord{
+		// Written by an older version straight on the name
+		{key: name, leaf: true, valid: true, record: DNSRecord{Host: "10.0.0.1", Owner: "proxmox/lab"}},
+		{key: name + "/a1", valid: true, record: DNSRecord{Host: "10.0.0.1", Owner: "proxmox/lab"}},
+		{key: name + "/a2", valid: true, record: DNSRecord{Host: "10.0.0.2", Owner: "proxmox/lab"}},
+		{key: name + "/aaaa1", valid: true, record: DNSRecord{Host: "fd00::1", Owner: "proxmox/lab"}},
+		{key: name + "/a3", valid: true, record: DNSRecord{Host: "10.0.0.3", Owner: "proxmox/other"}},
+		{key: name + "/edge", valid: true, record: DNSRecord{Host: "edge.example.com", Owner: "proxmox/lab"}},
+		{key: name + "/srv-http", valid: true, record: DNSRecord{Host: "web.example.com", Port: 80, Owner: "proxmox/lab"}},
+		{key: name + "/a4"},
+	}
+
+	got := staleRecordKeys(stored, []string{name + "/a1"}, "proxmox/lab")
+	want := []string{name, name + "/a2", name + "/aaaa1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("staleRecordKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestStaleRecordKeysLegacy(t *testing.T) {
+	const name = "/skydns/com/example/web"
+	stored := []storedRecord{
+		// Records of a version that did not store owners
+		{key: name, leaf: true, valid: true, record: DNSRecord{Host: "traefik.example.com"}},
+		{key: name + "/a1", valid: true, record: DNSRecord{Host: "10.0.0.1"}},
+		{key: name + "/a2", valid: true, record: DNSRecord{Host: "10.0.0.2"}},
+		{key: name + "/backup", valid: true, record: DNSRecord{Host: "10.0.0.5"}},
+	}
+
+	tests := []struct {
+		name  string
+		keys  []string
+		owner string
+		want  []string
+	}{
+		{name: "proxmox", keys: []string{name + "/a1"}, owner: "proxmox/lab", want: []string{name + "/a2"}},
+		{name: "docker", keys: []string{name + "/docker-1"}, owner: "docker/docker-1", want: []string{name}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := staleRecordKeys(stored, tt.keys, tt.owner); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("staleRecordKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TXTRecord is a TXT record published on behalf of a host
+type TXTRecord struct {
+	// Name is "" or "@" for the host itself, a relative label such as
+	// "_acme-challenge" placed below the host, or a fully qualified name
+	Name string
+	Text string
+}
+
+// txtRecordsFromMap converts name -> text pairs into TXT records in a stable order
+func txtRecordsFromMap(texts map[string]string) []TXTRecord {
+	names := make([]string, 0, len(texts))
+	for name := range texts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	records := make([]TXTRecord, 0, len(names))
+	for _, name := range names {
+		records = append(records, TXTRecord{Name: name, Text: texts[name]})
+	}
+	return records
+}
+
+// txtName returns the owner name of a TXT record published for hostname
+func txtName(record TXTRecord, hostname string) string {
+	name := strings.TrimSuffix(record.Name, ".")
+	switch {
+	case name == "" || name == "@":
+		return hostname
+	case strings.Contains(name, "."):
+		return name
+	default:
+		return name + "." + hostname
+	}
+}
+
+// onHost reports whether the TXT record sits on the host name itself
+func (r TXTRecord) onHost() bool {
+	return r.Name == "" || r.Name == "@"
+}
+
+// CreateTXTRecords publishes TXT records for hostname and returns the keys
+// written. Keys carry the host and owner so several hosts, or several agents
+// serving the same host, can publish the same name. Records refused by
+// CONFLICT_POLICY do not stop the others; they are returned as
+// errConflictRefused.
+func (ec *EtcdClient) CreateTXTRecords(hostname string, records []TXTRecord, ttl int, owner string) ([]string, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var keys []string
+	var refused []error
+	counts := make(map[string]int)
+	for _, txt := range records {
+		name := txtName(txt, hostname)
+		counts[name]++
+		key := fmt.Sprintf("%s/txt%d-%s-%s", ec.hostKey(name), counts[name], recordSubKey(hostname), ownerSubKey(owner))
+
+		record := DNSRecord{Text: txt.Text, TTL: ec.recordTTL(ttl), Owner: owner}
+		err := ec.putOwnedRecord(ctx, name, key, record)
+		if errors.Is(err, errConflictRefused) {
+			refused = append(refused, fmt.Errorf("TXT record %s: %w", name, err))
+			continue
+		} else if err != nil {
+			return keys, fmt.Errorf("failed to create TXT record %s: %w", name, err)
+		}
+		keys = append(keys, key)
+
+		log.WithFields(map[string]interface{}{
+			"name":  name,
+			"text":  txt.Text,
+			"type":  "TXT",
+			"owner": owner,
+		}).Info("Created DNS record")
+	}
+
+	return keys, errors.Join(refused...)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultView is the name of the view published under ETCD_PREFIX
+const defaultView = "default"
+
+// View is a split-horizon view and the client publishing its records
+type View struct {
+	Name       string
+	EtcdClient *EtcdClient
+}
+
+// NewViews connects a client for each of the configured views
+func NewViews(config Config) ([]View, error) {
+	var views []View
+	for _, view := range config.Views {
+		etcdClient, err := NewEtcdClient(viewConfig(config, view))
+		if err != nil {
+			closeViews(views)
+			return nil, fmt.Errorf("view %s: %w", view.Name, err)
+		}
+		views = append(views, View{Name: view.Name, EtcdClient: etcdClient})
+	}
+	return views, nil
+}
+
+// reconfigureViews applies config to the clients of views
+func reconfigureViews(views []View, config Config) error {
+	for _, view := range views {
+		for _, configured := range config.Views {
+			if configured.Name != view.Name {
+				continue
+			}
+			if err := view.EtcdClient.Reconfigure(viewConfig(config, configured)); err != nil {
+				return fmt.Errorf("view %s: %w", view.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// closeViews closes the clients of views
+func closeViews(views []View) {
+	for _, view := range views {
+		view.EtcdClient.Close()
+	}
+}
+
+// containerInView reports whether a container is published in view. The
+// dnsherpa.views label limits a container to the listed views; without it
+// the container is published in all of them.
+func containerInView(labels map[string]string, view string) bool {
+	value, found := labels["dnsherpa.views"]
+	if !found {
+		return true
+	}
+	for _, name := range splitList(value) {
+		if name == view {
+			return true
+		}
+	}
+	return false
+}
+
+// containerViewTarget returns the target set for view by a
+// dnsherpa.view.<name>.target label, if any
+func containerViewTarget(labels map[string]string, view string) string {
+	return strings.TrimSpace(labels["dnsherpa.view."+view+".target"])
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Watch policies for records changed outside DNSherpa
+const (
+	WatchRepair = "repair"
+	WatchAlert  = "alert"
+	WatchOff    = "off"
+)
+
+// watchRetryDelay is how long to wait before re-establishing a failed watch
+const watchRetryDelay = 5 * time.Second
+
+// validateWatchPolicy checks WATCH_POLICY
+func validateWatchPolicy(policy string) error {
+	switch policy {
+	case WatchRepair, WatchAlert, WatchOff:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q (valid options: %s, %s, %s)", policy, WatchRepair, WatchAlert, WatchOff)
+}
+
+// remember records the value DNSherpa is about to write to key. It is called
+// before the write so the watch never mistakes our own write for tampering;
+// the returned function restores the previous state if the write fails.
+func (ec *EtcdClient) remember(key, value string) (undo func()) {
+	ec.managedMu.Lock()
+	defer ec.managedMu.Unlock()
+
+	previous, found := ec.managed[key]
+	ec.managed[key] = value
+	return func() {
+		ec.managedMu.Lock()
+		defer ec.managedMu.Unlock()
+
+		if found {
+			ec.managed[key] = previous
+		} else {
+			delete(ec.managed, key)
+		}
+	}
+}
+
+// managedValue returns the value DNSherpa last wrote to key
+func (ec *EtcdClient) managedValue(key string) (string, bool) {
+	ec.managedMu.Lock()
+	defer ec.managedMu.Unlock()
+
+	value, found := ec.managed[key]
+	return value, found
+}
+
+// ForgetOwner stops tracking the records of owner, for example when another
+// instance took over the source after a leadership change
+func (ec *EtcdClient) ForgetOwner(owner string) {
+	ec.managedMu.Lock()
+	var keys []string
+	for key, value := range ec.managed {
+		var record DNSRecord
+		if json.Unmarshal([]byte(value), &record) == nil && record.Owner == owner {
+			keys = append(keys, key)
+		}
+	}
+	ec.managedMu.Unlock()
+
+	ec.forgetRecords(keys)
+}
+
+// WatchManagedRecords watches the etcd prefix and repairs, or only reports,
+// records written by this instance that were edited or deleted by someone
+// else. It runs until ctx is cancelled.
+func (ec *EtcdClient) WatchManagedRecords(ctx context.Context) {
+	for {
+		// Catch up on anything missed while the watch was down
+		if err := ec.verifyManagedRecords(ctx); err != nil {
+			log.WithError(err).Error("Failed to verify managed DNS records")
+		}
+
+		for resp := range ec.etcd().Watch(ctx, ec.settings().EtcdPrefix+"/", clientv3.WithPrefix()) {
+			if err := resp.Err(); err != nil {
+				log.WithError(err).Warn("etcd watch interrupted")
+				break
+			}
+			for _, event := range resp.Events {
+				ec.handleWatchEvent(ctx, event)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryDelay):
+			log.Debug("Re-establishing etcd watch")
+		}
+	}
+}
+
+// handleWatchEvent checks a changed key against the value DNSherpa wrote to it
+func (ec *EtcdClient) handleWatchEvent(ctx context.Context, event *clientv3.Event) {
+	key := string(event.Kv.Key)
+	expected, found := ec.managedValue(key)
+	if !found {
+		return
+	}
+
+	if event.Type == clientv3.EventTypeDelete {
+		ec.restoreRecord(ctx, key, expected, event.Kv.ModRevision)
+	} else if string(event.Kv.Value) != expected {
+		ec.restoreRecord(ctx, key, expected, 0)
+	}
+}
+
+// verifyManagedRecords compares every managed key with etcd
+func (ec *EtcdClient) verifyManagedRecords(ctx context.Context) error {
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	done := etcdTimer("get")
+	resp, err := ec.etcd().Get(listCtx, ec.settings().EtcdPrefix+"/", clientv3.WithPrefix())
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to list DNS records: %w", err)
+	}
+	current := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		current[string(kv.Key)] = string(kv.Value)
+	}
+
+	ec.managedMu.Lock()
+	expected := make(map[string]string, len(ec.managed))
+	for key, value := range ec.managed {
+		expected[key] = value
+	}
+	ec.managedMu.Unlock()
+
+	for key, value := range expected {
+		if stored, found := current[key]; !found || stored != value {
+			ec.restoreRecord(ctx, key, value, 0)
+		}
+	}
+	return nil
+}
+
+// restoreRecord checks key against the value DNSherpa wrote to it and, if it
+// was edited or deleted, reports it and writes the expected value back unless
+// WATCH_POLICY is alert. Records taken over by another DNSherpa owner are left
+// to the conflict policy. deletedAt is the revision of a delete event, 0 if
+// it is unknown.
+func (ec *EtcdClient) restoreRecord(ctx context.Context, key, expected string, deletedAt int64) {
+	repairCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	done := etcdTimer("get")
+	resp, err := ec.etcd().Get(repairCtx, key)
+	done()
+	if err != nil {
+		log.WithFields(map[string]interface{}{
+			"key":   key,
+			"error": err,
+		}).Error("Failed to check managed DNS record")
+		return
+	}
+
+	// Events of our own earlier writes are not tampering
+	if current, stillManaged := ec.managedValue(key); !stillManaged || current != expected {
+		return
+	}
+	change := "deleted"
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if len(resp.Kvs) > 0 {
+		if string(resp.Kvs[0].Value) == expected {
+			return
+		}
+		change = "edited"
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+	}
+
+	ec.countTampering(change)
+
+	var record DNSRecord
+	_ = json.Unmarshal([]byte(expected), &record)
+	policy := ec.settings().WatchPolicy
+	entry := log.WithFields(map[string]interface{}{
+		"key":    key,
+		"owner":  record.Owner,
+		"change": change,
+		"policy": policy,
+	})
+
+	if policy == WatchAlert {
+		entry.Warn("Managed DNS record changed outside DNSherpa")
+		return
+	}
+
+	if change == "edited" {
+		var existing DNSRecord
+		if json.Unmarshal(resp.Kvs[0].Value, &existing) == nil && existing.Owner != "" && existing.Owner != record.Owner {
+			entry.WithField("existing_owner", existing.Owner).Warn("Managed DNS record taken over by another owner, not repairing")
+			ec.forgetRecords([]string{key})
+			return
+		}
+	}
+
+	// A takeover deletes our record in the same transaction that writes the
+	// new owner's records at the name
+	if change == "deleted" && deletedAt > 0 {
+		stored, err := ec.readNameKey(repairCtx, ec.nameKeyOf(key))
+		if err != nil {
+			entry.WithError(err).Error("Failed to check managed DNS record")
+			return
+		}
+		for _, other := range stored {
+			if other.valid && other.modRevision == deletedAt && other.record.Owner != "" && other.record.Owner != record.Owner {
+				entry.WithField("existing_owner", other.record.Owner).Warn("Managed DNS record taken over by another owner, not repairing")
+				ec.forgetRecords([]string{key})
+				return
+			}
+		}
+	}
+
+	// Only write back if the key was not changed again in the meantime
+	done = etcdTimer("txn")
+	txn, err := ec.etcd().Txn(repairCtx).If(cmp).Then(clientv3.OpPut(key, expected)).Commit()
+	done()
+	if err != nil {
+		recordFailures.WithLabelValues(ec.settings().EtcdPrefix, "write").Inc()
+		entry.WithError(err).Error("Failed to repair managed DNS record")
+		return
+	}
+	if txn.Succeeded {
+		recordWrites.WithLabelValues(ec.settings().EtcdPrefix).Inc()
+		entry.Warn("Repaired managed DNS record changed outside DNSherpa")
+	}
+}
+
+// countTampering counts an out-of-band change of a managed record
+func (ec *EtcdClient) countTampering(change string) {
+	ec.statsMu.Lock()
+	defer ec.statsMu.Unlock()
+
+	ec.tampering[change]++
+}
+
+// TamperingCounts returns the number of managed records changed outside
+// DNSherpa, keyed by "deleted" or "edited"
+func (ec *EtcdClient) TamperingCounts() map[string]int {
+	ec.statsMu.Lock()
+	defer ec.statsMu.Unlock()
+
+	counts := make(map[string]int, len(ec.tampering))
+	for change, count := range ec.tampering {
+		counts[change] = count
+	}
+	return counts
+}
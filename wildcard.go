@@ -0,0 +1,42 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Traefik v2 placeholder syntax: {subdomain:[a-z]+}.example.com
+	hostRegexpV2 = regexp.MustCompile(`^\{[^:}]+(:.*)?\}\.([A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*)$`)
+
+	// Traefik v3 regular expression syntax: ^[a-z0-9-]+\.example\.com$
+	hostRegexpV3 = regexp.MustCompile(`^\^?(.+?)\\\.((?:[A-Za-z0-9-]+\\\.)*[A-Za-z0-9-]+)\$?$`)
+)
+
+// wildcardFromHostRegexp maps a Traefik HostRegexp pattern that matches any
+// subdomain of a literal domain onto the wildcard name *.domain
+func wildcardFromHostRegexp(pattern string) (string, bool) {
+	pattern = strings.TrimSpace(pattern)
+
+	if match := hostRegexpV2.FindStringSubmatch(pattern); match != nil {
+		return "*." + match[2], true
+	}
+
+	if match := hostRegexpV3.FindStringSubmatch(pattern); match != nil {
+		// The leading part must be a pattern for the subdomain, not a literal label
+		if !strings.ContainsAny(match[1], `[]().+*?\`) {
+			return "", false
+		}
+		return "*." + strings.ReplaceAll(match[2], `\.`, "."), true
+	}
+
+	return "", false
+}
+
+// wildcardName returns the wildcard name covering every subdomain of hostname
+func wildcardName(hostname string) string {
+	if strings.HasPrefix(hostname, "*.") {
+		return hostname
+	}
+	return "*." + hostname
+}
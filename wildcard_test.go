@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestWildcardFromHostRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		ok      bool
+	}{
+		{pattern: "{subdomain:[a-z]+}.example.com", want: "*.example.com", ok: true},
+		{pattern: "{name}.apps.example.com", want: "*.apps.example.com", ok: true},
+		{pattern: `^[a-z0-9-]+\.example\.com$`, want: "*.example.com", ok: true},
+		{pattern: ` ^.+\.apps\.example\.com$ `, want: "*.apps.example.com", ok: true},
+		{pattern: `^www\.example\.com$`, ok: false},
+		{pattern: "example.com", ok: false},
+		{pattern: "{subdomain:[a-z]+}.{domain}", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, ok := wildcardFromHostRegexp(tt.pattern)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("wildcardFromHostRegexp(%q) = %q, %v, want %q, %v", tt.pattern, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}